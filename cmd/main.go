@@ -1,14 +1,19 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 
 	"github.com/tommy351/cert-uploader/internal/controller"
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/internal/webhook"
 	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
-	"go.uber.org/zap/zapcore"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -18,19 +23,27 @@ import (
 // +kubebuilder:rbac:groups="",namespace=cert-uploader,resources=configmaps,verbs=get;create;update
 // +kubebuilder:rbac:groups="coordination.k8s.io",namespace=cert-uploader,resources=leases,verbs=get;create;update
 
-// nolint: gochecknoinits
-func init() {
-	log.SetLogger(zap.New(func(opts *zap.Options) {
-		if !opts.Development {
-			zap.JSONEncoder(func(ec *zapcore.EncoderConfig) {
-				ec.EncodeTime = zapcore.ISO8601TimeEncoder
-				ec.TimeKey = "time"
-			})(opts)
-		}
-	}))
-}
-
 func main() {
+	opts, err := parseOptions(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logOpts, err := opts.loggerOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	log.SetLogger(zap.New(logOpts...))
+
+	uploadSelector, secretSelector, err := opts.selectors()
+	if err != nil {
+		log.Log.Error(err, "invalid selector")
+		os.Exit(2)
+	}
+
 	scheme := runtime.NewScheme()
 	sb := runtime.NewSchemeBuilder(
 		corev1.AddToScheme,
@@ -42,19 +55,80 @@ func main() {
 		os.Exit(1)
 	}
 
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
-		Scheme:           scheme,
-		LeaderElection:   true,
-		LeaderElectionID: "cert-uploader-controller-lock",
-	})
+	mgrOpts := manager.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      opts.MetricsBindAddress,
+		HealthProbeBindAddress:  opts.HealthProbeBindAddress,
+		LeaderElection:          opts.LeaderElection,
+		LeaderElectionID:        opts.LeaderElectionID,
+		LeaderElectionNamespace: opts.LeaderElectionNamespace,
+		SyncPeriod:              &opts.SyncPeriod,
+		Port:                    opts.WebhookPort,
+	}
+
+	namespaces := opts.watchNamespaces()
+	if namespaces != nil {
+		log.Log.Info("Watching namespaces", "namespaces", namespaces)
+		mgrOpts.NewCache = controller.NamespacedCache(namespaces)
+	}
+
+	if opts.CacheOnlyTLSSecrets {
+		newCache := mgrOpts.NewCache
+		if newCache == nil {
+			newCache = cache.New
+		}
+
+		mgrOpts.NewCache = controller.SecretCache(newCache, namespaces, controller.TLSSecretSelector, secretSelector)
+	}
+
+	mgr, err := manager.New(config.GetConfigOrDie(), mgrOpts)
 	if err != nil {
 		log.Log.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
 	}
 
+	uploaders := uploader.NewDefaultRegistry(mgr.GetClient())
+	enabledProviders := map[string]bool{}
+
+	for _, name := range splitList(opts.Providers) {
+		if uploaders.Get(name) == nil {
+			log.Log.Error(fmt.Errorf("unknown provider %q", name), "invalid providers")
+			os.Exit(1)
+		}
+
+		enabledProviders[name] = true
+	}
+
+	for _, name := range uploaders.Names() {
+		uploaders.SetEnabled(name, enabledProviders[name])
+	}
+
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		log.Log.Error(err, "failed to add health check")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddReadyzCheck("cache", controller.CacheSyncCheck(mgr.GetCache())); err != nil {
+		log.Log.Error(err, "failed to add readiness check")
+		os.Exit(1)
+	}
+
+	if opts.ReadinessCheckProviders {
+		if err := mgr.AddReadyzCheck("providers", controller.ProviderCheck(uploaders)); err != nil {
+			log.Log.Error(err, "failed to add readiness check")
+			os.Exit(1)
+		}
+	}
+
 	cur := &controller.CertificateUploadReconciler{
-		Client:        mgr.GetClient(),
-		EventRecorder: mgr.GetEventRecorderFor("cert-uploader"),
+		Client:                   mgr.GetClient(),
+		EventRecorder:            mgr.GetEventRecorderFor("cert-uploader"),
+		Uploaders:                uploaders,
+		ResyncInterval:           opts.ResyncInterval,
+		ClusterResourceNamespace: opts.ClusterResourceNamespace,
+		MaxConcurrentReconciles:  opts.MaxConcurrentReconciles,
+		Selector:                 uploadSelector,
+		SecretSelector:           secretSelector,
 	}
 
 	if err := cur.SetupWithManager(mgr); err != nil {
@@ -62,9 +136,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	ccur := &controller.ClusterCertificateUploadReconciler{
+		CertificateUploadReconciler: cur,
+	}
+
+	if err := ccur.SetupWithManager(mgr); err != nil {
+		log.Log.Error(err, "failed to setup reconciler")
+		os.Exit(1)
+	}
+
 	sr := &controller.SecretReconciler{
 		Client:                      mgr.GetClient(),
+		EventRecorder:               mgr.GetEventRecorderFor("cert-uploader"),
 		CertificateUploadReconciler: cur,
+		Selector:                    secretSelector,
 	}
 
 	if err := sr.SetupWithManager(mgr); err != nil {
@@ -72,6 +157,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.EnableWebhooks {
+		cuw := &webhook.CertificateUploadWebhook{
+			Client:                   mgr.GetClient(),
+			ClusterResourceNamespace: opts.ClusterResourceNamespace,
+		}
+
+		if err := cuw.SetupWithManager(mgr); err != nil {
+			log.Log.Error(err, "failed to setup webhook")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		log.Log.Error(err, "failed to start manager")
 		os.Exit(1)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix is the prefix of environment variables overriding flags. For
+// example, CERT_UPLOADER_LOG_LEVEL sets --log-level.
+const envPrefix = "CERT_UPLOADER_"
+
+type options struct {
+	ConfigFile               string
+	Providers                string
+	ResyncInterval           time.Duration
+	EnableWebhooks           bool
+	ClusterResourceNamespace string
+	WebhookPort              int
+	MetricsBindAddress       string
+	HealthProbeBindAddress   string
+	ReadinessCheckProviders  bool
+	LeaderElection           bool
+	LeaderElectionID         string
+	LeaderElectionNamespace  string
+	Namespaces               string
+	UploadSelector           string
+	SecretSelector           string
+	CacheOnlyTLSSecrets      bool
+	MaxConcurrentReconciles  int
+	SyncPeriod               time.Duration
+	LogLevel                 string
+	LogFormat                string
+}
+
+func (o *options) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "config", "", "Path to a YAML file containing values of flags, e.g. \"log-level: debug\"")
+	fs.StringVar(&o.Providers, "providers", "cloudflare,acm,gcp", "Comma-separated list of enabled providers. Certificates uploaded to disabled providers are not deleted with their resources")
+	fs.DurationVar(&o.ResyncInterval, "resync-interval", 0, "How often uploaded certificates are compared with secrets. Disabled if it is zero")
+	fs.BoolVar(&o.EnableWebhooks, "enable-webhooks", false, "Serve admission webhooks for validating and defaulting resources")
+	fs.StringVar(&o.ClusterResourceNamespace, "cluster-resource-namespace", "cert-uploader", "Namespace where secrets referenced by ClusterProviders and ClusterCertificateUploads are read from")
+	fs.IntVar(&o.WebhookPort, "webhook-port", 9443, "Port of the webhook server")
+	fs.StringVar(&o.MetricsBindAddress, "metrics-bind-address", ":8080", "Address the metrics endpoint binds to. Disabled if it is \"0\"")
+	fs.StringVar(&o.HealthProbeBindAddress, "health-probe-bind-address", ":8081", "Address the health probe endpoints bind to. Disabled if it is \"0\"")
+	fs.BoolVar(&o.ReadinessCheckProviders, "readiness-check-providers", false, "Report not ready when APIs of enabled providers are unreachable")
+	fs.BoolVar(&o.LeaderElection, "leader-elect", true, "Enable leader election, so only one instance is active at a time")
+	fs.StringVar(&o.LeaderElectionID, "leader-election-id", "cert-uploader-controller-lock", "Name of the resource used for leader election")
+	fs.StringVar(&o.LeaderElectionNamespace, "leader-election-namespace", "", "Namespace of the resource used for leader election. Defaults to the namespace of the pod")
+	fs.StringVar(&o.Namespaces, "namespaces", "", "Comma-separated list of namespaces to watch. All namespaces are watched if it is empty")
+	fs.StringVar(&o.UploadSelector, "upload-selector", "", "Label selector of CertificateUploads and ClusterCertificateUploads to reconcile. All of them are reconciled if it is empty")
+	fs.StringVar(&o.SecretSelector, "secret-selector", "", "Label selector of secrets to watch for changes and upload annotations. All secrets are watched if it is empty")
+	fs.BoolVar(&o.CacheOnlyTLSSecrets, "cache-only-tls-secrets", false, "Only cache TLS secrets matching --secret-selector to reduce memory usage. Other secrets are read from the API server, so changes of provider credentials are not watched")
+	fs.IntVar(&o.MaxConcurrentReconciles, "max-concurrent-reconciles", 1, "Maximum number of concurrent reconciles of each controller")
+	fs.DurationVar(&o.SyncPeriod, "sync-period", 10*time.Hour, "How often all watched resources are reconciled")
+	fs.StringVar(&o.LogLevel, "log-level", "info", "Log level: debug, info or error")
+	fs.StringVar(&o.LogFormat, "log-format", "json", "Log format: json or console")
+}
+
+// parseOptions parses flags from the command line. Flags which are not set on
+// the command line are read from environment variables and then from the
+// config file.
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
+	opts := new(options)
+	opts.bindFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	explicit := map[string]bool{}
+
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	values := map[string]string{}
+
+	if opts.ConfigFile != "" {
+		var err error
+
+		if values, err = readConfigFile(opts.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			values[f.Name] = value
+		}
+	})
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+		}
+	}
+
+	return opts, nil
+}
+
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfigFile returns flag values in a YAML file. Lists are joined with
+// commas.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	values := map[string]string{}
+
+	for name, value := range raw {
+		switch v := value.(type) {
+		case []interface{}:
+			items := make([]string, len(v))
+
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+
+			values[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("invalid value for %s in config file: objects are not supported", name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// watchNamespaces returns namespaces to watch, or nil if all namespaces are
+// watched. The cluster resource namespace is always included because secrets
+// of cluster-scoped resources are read from it.
+func (o *options) watchNamespaces() []string {
+	namespaces := splitList(o.Namespaces)
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	for _, ns := range namespaces {
+		if ns == o.ClusterResourceNamespace {
+			return namespaces
+		}
+	}
+
+	return append(namespaces, o.ClusterResourceNamespace)
+}
+
+// selectors returns label selectors of uploads and secrets. A selector is nil if
+// it is not set.
+func (o *options) selectors() (upload, secret labels.Selector, err error) {
+	if upload, err = parseSelector(o.UploadSelector); err != nil {
+		return nil, nil, fmt.Errorf("invalid upload selector: %w", err)
+	}
+
+	if secret, err = parseSelector(o.SecretSelector); err != nil {
+		return nil, nil, fmt.Errorf("invalid secret selector: %w", err)
+	}
+
+	return upload, secret, nil
+}
+
+func parseSelector(s string) (labels.Selector, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	return labels.Parse(s)
+}
+
+func (o *options) loggerOptions() ([]zap.Opts, error) {
+	var level zapcore.Level
+
+	if err := level.UnmarshalText([]byte(o.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", o.LogLevel, err)
+	}
+
+	encoderConfig := func(ec *zapcore.EncoderConfig) {
+		ec.EncodeTime = zapcore.ISO8601TimeEncoder
+		ec.TimeKey = "time"
+	}
+
+	opts := []zap.Opts{zap.Level(level)}
+
+	switch o.LogFormat {
+	case "json":
+		opts = append(opts, zap.JSONEncoder(encoderConfig))
+	case "console":
+		opts = append(opts, zap.ConsoleEncoder(encoderConfig))
+	default:
+		return nil, fmt.Errorf("invalid log format %q", o.LogFormat)
+	}
+
+	return opts, nil
+}
+
+func splitList(s string) []string {
+	var result []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
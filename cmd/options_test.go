@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Unsetenv(key)
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		opts, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.LogLevel != "info" || opts.Providers != "cloudflare,acm,gcp" || !opts.LeaderElection {
+			t.Fatalf("unexpected defaults: %+v", opts)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		path := writeConfigFile(t, `
+log-level: error
+log-format: console
+namespaces:
+  - foo
+  - bar
+resync-interval: 1h
+`)
+		setEnv(t, "CERT_UPLOADER_LOG_FORMAT", "json")
+		setEnv(t, "CERT_UPLOADER_RESYNC_INTERVAL", "30m")
+
+		opts, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+			"--config", path,
+			"--resync-interval", "10m",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Config file
+		if opts.LogLevel != "error" {
+			t.Errorf("expected log level error, got %s", opts.LogLevel)
+		}
+
+		if opts.Namespaces != "foo,bar" {
+			t.Errorf("expected namespaces foo,bar, got %s", opts.Namespaces)
+		}
+
+		// Environment variables override the config file
+		if opts.LogFormat != "json" {
+			t.Errorf("expected log format json, got %s", opts.LogFormat)
+		}
+
+		// Flags override everything
+		if opts.ResyncInterval != 10*time.Minute {
+			t.Errorf("expected resync interval 10m, got %v", opts.ResyncInterval)
+		}
+	})
+
+	t.Run("invalid environment variable", func(t *testing.T) {
+		setEnv(t, "CERT_UPLOADER_LEADER_ELECT", "maybe")
+
+		if _, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("objects in config file", func(t *testing.T) {
+		path := writeConfigFile(t, "namespaces:\n  foo: bar\n")
+
+		if _, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("missing config file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing.yml")
+
+		if _, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -3,11 +3,17 @@ module github.com/tommy351/cert-uploader
 go 1.15
 
 require (
+	github.com/aws/aws-sdk-go v1.36.0
 	github.com/cloudflare/cloudflare-go v0.13.6
+	github.com/onsi/ginkgo v1.14.1
+	github.com/onsi/gomega v1.10.2
+	github.com/prometheus/client_golang v1.7.1
 	go.uber.org/zap v1.15.0
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	k8s.io/api v0.20.0
 	k8s.io/apimachinery v0.20.0
 	k8s.io/client-go v0.20.0
 	sigs.k8s.io/controller-runtime v0.7.0
 	sigs.k8s.io/controller-tools v0.4.1
+	sigs.k8s.io/yaml v1.2.0
 )
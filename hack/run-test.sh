@@ -3,6 +3,7 @@
 set -euo pipefail
 
 export JUNIT_OUTPUT="${PWD}/reports/junit"
+export KUBEBUILDER_ASSETS="${KUBEBUILDER_ASSETS:-${PWD}/assets/bin}"
 
 go run github.com/onsi/ginkgo/ginkgo \
   -r \
@@ -20,6 +20,12 @@ go run sigs.k8s.io/controller-tools/cmd/controller-gen \
   paths="${PROJECT_ROOT}/cmd/...;${PROJECT_ROOT}/internal/..." \
   output:rbac:artifacts:config="${PROJECT_ROOT}/deployment/base/rbac"
 
+echo "Generate webhooks"
+go run sigs.k8s.io/controller-tools/cmd/controller-gen \
+  webhook \
+  paths="${PROJECT_ROOT}/internal/..." \
+  output:webhook:artifacts:config="${PROJECT_ROOT}/deployment/webhook"
+
 echo "Generate Go files"
 go generate ./...
 
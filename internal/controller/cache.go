@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+var (
+	errCacheOptionsRequired = errors.New("scheme and mapper are required")
+
+	// ErrNamespaceNotWatched is returned when a namespaced object is read
+	// from a namespace which is not watched by the cache.
+	ErrNamespaceNotWatched = errors.New("namespace is not watched")
+)
+
+// NamespacedCache returns a cache watching namespaced resources only in the
+// given namespaces. Unlike cache.MultiNamespacedCacheBuilder, cluster-scoped
+// resources like ClusterCertificateUploads are read from a cluster-wide cache,
+// so they are neither missing from Get nor duplicated in List. Getting objects
+// from other namespaces returns ErrNamespaceNotWatched.
+func NamespacedCache(namespaces []string) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		if opts.Scheme == nil || opts.Mapper == nil {
+			return nil, errCacheOptionsRequired
+		}
+
+		clusterOpts := opts
+		clusterOpts.Namespace = ""
+
+		cluster, err := cache.New(config, clusterOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cluster cache: %w", err)
+		}
+
+		namespaced, err := cache.MultiNamespacedCacheBuilder(namespaces)(config, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create namespaced cache: %w", err)
+		}
+
+		watched := make(map[string]bool, len(namespaces))
+
+		for _, ns := range namespaces {
+			watched[ns] = true
+		}
+
+		return &namespacedCache{
+			cluster:    cluster,
+			namespaced: namespaced,
+			namespaces: watched,
+			scheme:     opts.Scheme,
+			mapper:     opts.Mapper,
+		}, nil
+	}
+}
+
+type namespacedCache struct {
+	cluster    cache.Cache
+	namespaced cache.Cache
+	namespaces map[string]bool
+	scheme     *runtime.Scheme
+	mapper     meta.RESTMapper
+}
+
+var _ cache.Cache = (*namespacedCache)(nil)
+
+func (c *namespacedCache) cacheForKind(gvk schema.GroupVersionKind) (cache.Cache, error) {
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get REST mapping of %s: %w", gvk, err)
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return c.cluster, nil
+	}
+
+	return c.namespaced, nil
+}
+
+func (c *namespacedCache) cacheForObject(obj runtime.Object) (cache.Cache, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+
+	return c.cacheForKind(gvk)
+}
+
+func (c *namespacedCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	cc, err := c.cacheForObject(obj)
+	if err != nil {
+		return err
+	}
+
+	if cc == c.namespaced && !c.namespaces[key.Namespace] {
+		return fmt.Errorf("%w: failed to get %s", ErrNamespaceNotWatched, key)
+	}
+
+	return cc.Get(ctx, key, obj)
+}
+
+func (c *namespacedCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	cc, err := c.cacheForObject(list)
+	if err != nil {
+		return err
+	}
+
+	return cc.List(ctx, list, opts...)
+}
+
+func (c *namespacedCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	cc, err := c.cacheForObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return cc.GetInformer(ctx, obj)
+}
+
+func (c *namespacedCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	cc, err := c.cacheForKind(gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	return cc.GetInformerForKind(ctx, gvk)
+}
+
+func (c *namespacedCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	cc, err := c.cacheForObject(obj)
+	if err != nil {
+		return err
+	}
+
+	return cc.IndexField(ctx, obj, field, extractValue)
+}
+
+func (c *namespacedCache) Start(ctx context.Context) error {
+	caches := []cache.Cache{c.cluster, c.namespaced}
+	errCh := make(chan error, len(caches))
+
+	for _, cc := range caches {
+		go func(cc cache.Cache) {
+			errCh <- cc.Start(ctx)
+		}(cc)
+	}
+
+	for range caches {
+		if err := <-errCh; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *namespacedCache) WaitForCacheSync(ctx context.Context) bool {
+	synced := c.cluster.WaitForCacheSync(ctx)
+
+	return c.namespaced.WaitForCacheSync(ctx) && synced
+}
+
+// isNamespaceNotWatched returns true if the object can't be read because its
+// namespace is not watched.
+func isNamespaceNotWatched(err error) bool {
+	return errors.Is(err, ErrNamespaceNotWatched)
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestNamespacedCache returns a cache watching namespaces "a" and "b".
+// The cache is not started, so nothing is read from the API server.
+func newTestNamespacedCache(t *testing.T) (*namespacedCache, *runtime.Scheme) {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	mapper.Add(v1alpha1.GroupVersion.WithKind(v1alpha1.CertificateUploadKind), meta.RESTScopeNamespace)
+	mapper.Add(v1alpha1.GroupVersion.WithKind(v1alpha1.ClusterCertificateUploadKind), meta.RESTScopeRoot)
+
+	c, err := NamespacedCache([]string{"a", "b"})(&rest.Config{Host: "http://localhost"}, cache.Options{
+		Scheme: scheme,
+		Mapper: mapper,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(*namespacedCache), scheme
+}
+
+func TestNamespacedCache(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestNamespacedCache(t)
+
+	t.Run("options required", func(t *testing.T) {
+		_, err := NamespacedCache([]string{"a"})(&rest.Config{Host: "http://localhost"}, cache.Options{})
+
+		if !errors.Is(err, errCacheOptionsRequired) {
+			t.Fatalf("expected errCacheOptionsRequired, got %v", err)
+		}
+	})
+
+	t.Run("cache for object", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			obj      runtime.Object
+			expected cache.Cache
+		}{
+			{name: "namespaced", obj: new(v1alpha1.CertificateUpload), expected: c.namespaced},
+			{name: "namespaced list", obj: new(v1alpha1.CertificateUploadList), expected: c.namespaced},
+			{name: "secret", obj: new(corev1.Secret), expected: c.namespaced},
+			{name: "cluster-scoped", obj: new(v1alpha1.ClusterCertificateUpload), expected: c.cluster},
+			{name: "cluster-scoped list", obj: new(v1alpha1.ClusterCertificateUploadList), expected: c.cluster},
+		}
+
+		for _, test := range tests {
+			actual, err := c.cacheForObject(test.obj)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+
+			if actual != test.expected {
+				t.Errorf("%s: object is read from the wrong cache", test.name)
+			}
+		}
+	})
+
+	t.Run("unknown kind", func(t *testing.T) {
+		if _, err := c.cacheForObject(new(corev1.ConfigMap)); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("get from watched namespace", func(t *testing.T) {
+		err := c.Get(ctx, types.NamespacedName{Namespace: "a", Name: "foo"}, new(corev1.Secret))
+
+		// The object is read from the underlying cache, which is not started
+		var notStarted *cache.ErrCacheNotStarted
+
+		if !errors.As(err, &notStarted) {
+			t.Fatalf("expected ErrCacheNotStarted, got %v", err)
+		}
+	})
+
+	t.Run("get from other namespace", func(t *testing.T) {
+		err := c.Get(ctx, types.NamespacedName{Namespace: "c", Name: "foo"}, new(corev1.Secret))
+
+		if !errors.Is(err, ErrNamespaceNotWatched) {
+			t.Fatalf("expected ErrNamespaceNotWatched, got %v", err)
+		}
+	})
+
+	t.Run("get cluster-scoped", func(t *testing.T) {
+		err := c.Get(ctx, types.NamespacedName{Name: "foo"}, new(v1alpha1.ClusterCertificateUpload))
+
+		// The object is read from the underlying cache, which is not started
+		var notStarted *cache.ErrCacheNotStarted
+
+		if !errors.As(err, &notStarted) {
+			t.Fatalf("expected ErrCacheNotStarted, got %v", err)
+		}
+	})
+}
+
+func TestSecretNamespaceNotWatched(t *testing.T) {
+	ctx := context.Background()
+	c, scheme := newTestNamespacedCache(t)
+
+	k8sClient, err := client.NewDelegatingClient(client.NewDelegatingClientInput{
+		CacheReader: c,
+		Client:      fakeclient.NewClientBuilder().WithScheme(scheme).Build(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReconciler(new(fake.Uploader))
+	r.Client = k8sClient
+	ccu := &v1alpha1.ClusterCertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: v1alpha1.ClusterCertificateUploadSpec{
+			SecretNamespace: "c",
+			CertificateUploadSpec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+			},
+		},
+	}
+
+	// The upload isn't requeued since the secret never shows up in the cache
+	result, err := r.uploadCertificate(ctx, ccu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	cond := meta.FindStatusCondition(ccu.Status.Conditions, v1alpha1.ConditionSecretValid)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != ReasonNamespaceNotWatched {
+		t.Fatalf("unexpected condition: %+v", cond)
+	}
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	ErrCertificateNotFound = errors.New("no certificate found in PEM data")
+	ErrPrivateKeyNotFound  = errors.New("no private key found in PEM data")
+	ErrUnknownPrivateKey   = errors.New("unknown private key type")
+)
+
+// certificateError describes why the content of a TLS secret can't be
+// uploaded.
+type certificateError struct {
+	Reason  string
+	Message string
+}
+
+// secretFingerprint returns the SHA-256 hash of the certificate chain and the
+// private key in a TLS secret.
+func secretFingerprint(secret *corev1.Secret) string {
+	h := sha256.New()
+	h.Write(secret.Data[corev1.TLSCertKey])
+	h.Write([]byte{0})
+	h.Write(secret.Data[corev1.TLSPrivateKeyKey])
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validateCertificate parses the certificate chain and the private key in a
+// TLS secret and checks whether they can be uploaded. The leaf certificate is
+// returned whenever it can be parsed, even if the validation failed.
+func validateCertificate(secret *corev1.Secret, now time.Time) (*x509.Certificate, *certificateError) {
+	chain, err := parseCertificateChain(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, &certificateError{
+			Reason:  ReasonInvalidCertificate,
+			Message: fmt.Sprintf("Failed to parse %s of secret %q: %v", corev1.TLSCertKey, secret.Name, err),
+		}
+	}
+
+	leaf := chain[0]
+
+	key, err := parsePrivateKey(secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return leaf, &certificateError{
+			Reason:  ReasonInvalidPrivateKey,
+			Message: fmt.Sprintf("Failed to parse %s of secret %q: %v", corev1.TLSPrivateKeyKey, secret.Name, err),
+		}
+	}
+
+	if !publicKeyMatches(key, leaf) {
+		for _, cert := range chain[1:] {
+			if publicKeyMatches(key, cert) {
+				return leaf, &certificateError{
+					Reason:  ReasonInvalidChain,
+					Message: fmt.Sprintf("Leaf certificate %q must be the first certificate in secret %q", cert.Subject, secret.Name),
+				}
+			}
+		}
+
+		return leaf, &certificateError{
+			Reason:  ReasonKeyMismatch,
+			Message: fmt.Sprintf("Private key of secret %q does not match the certificate", secret.Name),
+		}
+	}
+
+	// Certificates must be ordered from the leaf to the root
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return leaf, &certificateError{
+				Reason:  ReasonInvalidChain,
+				Message: fmt.Sprintf("Certificate %q in secret %q is not issued by %q: %v", chain[i-1].Subject, secret.Name, chain[i].Subject, err),
+			}
+		}
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return leaf, &certificateError{
+			Reason:  ReasonCertNotYetValid,
+			Message: fmt.Sprintf("Certificate of secret %q is not valid until %s", secret.Name, leaf.NotBefore.UTC()),
+		}
+	}
+
+	if now.After(leaf.NotAfter) {
+		return leaf, &certificateError{
+			Reason:  ReasonCertExpired,
+			Message: fmt.Sprintf("Certificate of secret %q expired at %s", secret.Name, leaf.NotAfter.UTC()),
+		}
+	}
+
+	return leaf, nil
+}
+
+func publicKeyMatches(key crypto.Signer, cert *x509.Certificate) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+func parseCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, ErrCertificateNotFound
+	}
+
+	return chain, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, ErrPrivateKeyNotFound
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, ErrUnknownPrivateKey
+			}
+
+			return signer, nil
+		}
+	}
+}
+
+// certificateDrift compares a certificate on a provider with the leaf
+// certificate in the secret. It returns a message describing the difference,
+// or an empty string if they match.
+func certificateDrift(remote *uploader.Certificate, leaf *x509.Certificate) string {
+	if !remote.ExpireTime.IsZero() && !remote.ExpireTime.Truncate(time.Second).Equal(leaf.NotAfter.Truncate(time.Second)) {
+		return fmt.Sprintf("expiration time %s does not match %s", remote.ExpireTime.UTC(), leaf.NotAfter.UTC())
+	}
+
+	if remote.SerialNumber != "" && normalizeSerialNumber(remote.SerialNumber) != normalizeSerialNumber(leaf.SerialNumber.Text(16)) {
+		return fmt.Sprintf("serial number %s does not match %s", remote.SerialNumber, leaf.SerialNumber.Text(16))
+	}
+
+	if len(remote.Hosts) > 0 && !equalHosts(remote.Hosts, leaf.DNSNames) {
+		return fmt.Sprintf("hosts %v do not match %v", remote.Hosts, leaf.DNSNames)
+	}
+
+	return ""
+}
+
+func normalizeSerialNumber(s string) string {
+	return strings.TrimLeft(strings.ToLower(strings.ReplaceAll(s, ":", "")), "0")
+}
+
+func equalHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch
+
+const (
+	certificateRefField = "spec.certificateRef"
+
+	// certificatePollInterval is how often a Certificate is read again while
+	// it is missing or not ready. Certificates aren't watched if cert-manager
+	// is installed after the controller is started.
+	certificatePollInterval = time.Minute
+)
+
+// nolint: gochecknoglobals
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+// newCertificate returns an empty cert-manager Certificate. Certificates are
+// read as unstructured objects, so cert-manager is not a dependency.
+func newCertificate() *unstructured.Unstructured {
+	u := new(unstructured.Unstructured)
+	u.SetGroupVersionKind(certificateGVK)
+
+	return u
+}
+
+// certificateKey returns the namespaced name of the Certificate referenced by
+// the resource.
+func certificateKey(cu uploadObject) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: cu.GetSecretKey().Namespace,
+		Name:      cu.GetUploadSpec().CertificateRef.Name,
+	}
+}
+
+// secretKey returns the namespaced name of the secret to upload. If the
+// resource refers to a Certificate, the secret of the Certificate when it was
+// last read is returned.
+func secretKey(cu uploadObject) types.NamespacedName {
+	key := cu.GetSecretKey()
+
+	if cu.GetUploadSpec().CertificateRef != nil {
+		key.Name = cu.GetUploadStatus().CertificateSecretName
+	}
+
+	return key
+}
+
+func indexCertificateRef(object client.Object) []string {
+	cu := object.(uploadObject)
+
+	if cu.GetUploadSpec().CertificateRef == nil {
+		return nil
+	}
+
+	return []string{certificateKey(cu).String()}
+}
+
+// watchCertificates indexes resources by referenced Certificates, and watches
+// Certificates if cert-manager is installed.
+func watchCertificates(mgr manager.Manager, b *builder.Builder, object client.Object, mapFn handler.MapFunc) (*builder.Builder, error) {
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), object, certificateRefField, indexCertificateRef); err != nil {
+		return nil, fmt.Errorf("index failed: %w", err)
+	}
+
+	if _, err := mgr.GetRESTMapper().RESTMapping(certificateGVK.GroupKind(), certificateGVK.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			log.Log.Info("Certificates are not watched because cert-manager is not installed")
+
+			return b, nil
+		}
+
+		return nil, fmt.Errorf("failed to get rest mapping of certificates: %w", err)
+	}
+
+	return b.Watches(&source.Kind{Type: newCertificate()}, handler.EnqueueRequestsFromMapFunc(mapFn)), nil
+}
+
+// certificateRequests returns requests of resources in the list type referring
+// to a Certificate.
+func (r *CertificateUploadReconciler) certificateRequests(object client.Object, list client.ObjectList) []reconcile.Request {
+	key := client.ObjectKeyFromObject(object).String()
+
+	if err := r.Client.List(context.Background(), list, client.MatchingFields(map[string]string{certificateRefField: key})); err != nil {
+		log.Log.Error(err, "Failed to list resources referring to the certificate", "certificate", key)
+
+		return nil
+	}
+
+	return listRequests(list)
+}
+
+// mapCertificate returns requests of CertificateUploads referring to a
+// Certificate.
+func (r *CertificateUploadReconciler) mapCertificate(object client.Object) []reconcile.Request {
+	return r.certificateRequests(object, new(v1alpha1.CertificateUploadList))
+}
+
+// getCertificate returns the Certificate referenced by the resource if it is
+// ready, or how long to wait before reading it again. The secret name of the
+// Certificate is recorded in the status, so the secret is followed when it is
+// renamed.
+//
+// Certificates are read as unstructured objects, which the client always
+// reads from the API server, even in namespaces which are not watched. The
+// secret is read from the cache, which reports namespaces not watched.
+func (r *CertificateUploadReconciler) getCertificate(ctx context.Context, cu uploadObject) (*unstructured.Unstructured, time.Duration, error) {
+	logger := log.FromContext(ctx)
+	key := certificateKey(cu)
+	certificate := newCertificate()
+
+	if err := r.Client.Get(ctx, key, certificate); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			logger.Error(err, "Certificate does not exist")
+			r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonCertificateNotFound, "Certificate %q does not exist", key)
+			setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionFalse, ReasonCertificateNotFound, fmt.Sprintf("Certificate %q does not exist", key))
+
+			return nil, certificatePollInterval, nil
+		}
+
+		return nil, 0, fmt.Errorf("failed to get certificate %q: %w", key, err)
+	}
+
+	secretName, _, _ := unstructured.NestedString(certificate.Object, "spec", "secretName")
+	cu.GetUploadStatus().CertificateSecretName = secretName
+
+	if ready, message := certificateReady(certificate); !ready {
+		logger.Info("Certificate is not ready", "message", message)
+		setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionFalse, ReasonCertificateNotReady, fmt.Sprintf("Waiting for Certificate %q: %s", key, message))
+
+		return nil, certificatePollInterval, nil
+	}
+
+	return certificate, 0, nil
+}
+
+// certificateReady returns whether the Certificate is ready and not being
+// issued, so the secret contains a complete certificate.
+func certificateReady(certificate *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	ready := false
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		switch cond["type"] {
+		case "Ready":
+			ready = cond["status"] == string(metav1.ConditionTrue)
+		case "Issuing":
+			if cond["status"] == string(metav1.ConditionTrue) {
+				return false, "Certificate is being issued"
+			}
+		}
+	}
+
+	if !ready {
+		return false, "Certificate is not ready"
+	}
+
+	return true, ""
+}
+
+// recordCertificateEvent records the result of an upload on the referenced
+// Certificate.
+func (r *CertificateUploadReconciler) recordCertificateEvent(cu uploadObject, certificate *unstructured.Unstructured, failures []*uploadFailure) {
+	if certificate == nil {
+		return
+	}
+
+	gvk, err := apiutil.GVKForObject(cu, r.Client.Scheme())
+	if err != nil {
+		return
+	}
+
+	key := client.ObjectKeyFromObject(cu)
+
+	if len(failures) > 0 {
+		r.EventRecorder.Eventf(certificate, corev1.EventTypeWarning, ReasonFailed, "Failed to upload certificate by %s %q", gvk.Kind, key)
+
+		return
+	}
+
+	r.EventRecorder.Eventf(certificate, corev1.EventTypeNormal, ReasonUploaded, "Certificate is uploaded by %s %q", gvk.Kind, key)
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestCertificateRef returns a cert-manager Certificate "default/foo" with
+// the Ready condition.
+func newTestCertificateRef(ready metav1.ConditionStatus) *unstructured.Unstructured {
+	certificate := newCertificate()
+	certificate.SetNamespace("default")
+	certificate.SetName("foo")
+	certificate.Object["spec"] = map[string]interface{}{"secretName": "foo-tls"}
+	certificate.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": string(ready)},
+		},
+	}
+
+	return certificate
+}
+
+func TestGetCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		ready   bool
+		poll    bool
+		reason  string
+	}{
+		{
+			name:    "ready",
+			objects: []client.Object{newTestCertificateRef(metav1.ConditionTrue)},
+			ready:   true,
+		},
+		{
+			name:    "not ready",
+			objects: []client.Object{newTestCertificateRef(metav1.ConditionFalse)},
+			poll:    true,
+			reason:  ReasonCertificateNotReady,
+		},
+		{
+			// The Certificate may be created after cert-manager is installed,
+			// which isn't watched
+			name:   "not found",
+			poll:   true,
+			reason: ReasonCertificateNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := v1alpha1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+
+			r := newTestReconciler(new(fake.Uploader))
+			r.Client = fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).Build()
+			cu := &v1alpha1.CertificateUpload{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+				Spec: v1alpha1.CertificateUploadSpec{
+					CertificateRef: &v1alpha1.CertificateReference{Name: "foo"},
+				},
+			}
+
+			certificate, requeueAfter, err := r.getCertificate(ctx, cu)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (certificate != nil) != test.ready {
+				t.Fatalf("unexpected certificate: %+v", certificate)
+			}
+
+			if (requeueAfter == certificatePollInterval) != test.poll {
+				t.Fatalf("unexpected requeue delay: %v", requeueAfter)
+			}
+
+			if test.reason == "" {
+				return
+			}
+
+			if cond := meta.FindStatusCondition(cu.Status.Conditions, v1alpha1.ConditionSecretValid); cond == nil || cond.Reason != test.reason {
+				t.Fatalf("unexpected condition: %+v", cond)
+			}
+		})
+	}
+}
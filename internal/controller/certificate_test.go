@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testCertificate struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate returns a certificate signed by the parent, or a
+// self-signed one if the parent is nil.
+func newTestCertificate(t *testing.T, name string, notBefore, notAfter time.Time, parent *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	issuer, signer := template, key
+
+	if parent != nil {
+		issuer, signer = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{Cert: cert, Key: key}
+}
+
+func newTestSecret(t *testing.T, key *ecdsa.PrivateKey, chain ...*testCertificate) *corev1.Secret {
+	t.Helper()
+
+	var certPEM []byte
+
+	for _, c := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})...)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(time.Hour)
+	ca := newTestCertificate(t, "ca", notBefore, notAfter, nil)
+	leaf := newTestCertificate(t, "example.com", notBefore, notAfter, ca)
+	other := newTestCertificate(t, "other", notBefore, notAfter, nil)
+
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		now    time.Time
+		reason string
+	}{
+		{
+			name:   "valid",
+			secret: newTestSecret(t, leaf.Key, leaf, ca),
+			now:    now,
+		},
+		{
+			name: "no certificate",
+			secret: &corev1.Secret{
+				Data: map[string][]byte{corev1.TLSCertKey: []byte("foo")},
+			},
+			now:    now,
+			reason: ReasonInvalidCertificate,
+		},
+		{
+			name: "no private key",
+			secret: func() *corev1.Secret {
+				s := newTestSecret(t, leaf.Key, leaf)
+				s.Data[corev1.TLSPrivateKeyKey] = nil
+
+				return s
+			}(),
+			now:    now,
+			reason: ReasonInvalidPrivateKey,
+		},
+		{
+			name:   "key mismatch",
+			secret: newTestSecret(t, other.Key, leaf),
+			now:    now,
+			reason: ReasonKeyMismatch,
+		},
+		{
+			name:   "leaf is not first",
+			secret: newTestSecret(t, leaf.Key, ca, leaf),
+			now:    now,
+			reason: ReasonInvalidChain,
+		},
+		{
+			name:   "not issued by next certificate",
+			secret: newTestSecret(t, leaf.Key, leaf, other),
+			now:    now,
+			reason: ReasonInvalidChain,
+		},
+		{
+			name:   "not yet valid",
+			secret: newTestSecret(t, leaf.Key, leaf, ca),
+			now:    notBefore.Add(-time.Minute),
+			reason: ReasonCertNotYetValid,
+		},
+		{
+			name:   "expired",
+			secret: newTestSecret(t, leaf.Key, leaf, ca),
+			now:    notAfter.Add(time.Minute),
+			reason: ReasonCertExpired,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			cert, err := validateCertificate(test.secret, test.now)
+
+			switch {
+			case test.reason == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err.Message)
+			case test.reason != "" && err == nil:
+				t.Fatalf("expected reason %s, got nil", test.reason)
+			case err != nil && err.Reason != test.reason:
+				t.Fatalf("expected reason %s, got %s: %s", test.reason, err.Reason, err.Message)
+			}
+
+			// The leaf certificate is returned whenever it can be parsed
+			if test.reason != ReasonInvalidCertificate && cert == nil {
+				t.Fatal("expected the leaf certificate to be returned")
+			}
+		})
+	}
+}
+
+func TestCertificateDrift(t *testing.T) {
+	now := time.Now()
+	leaf := newTestCertificate(t, "example.com", now.Add(-time.Hour), now.Add(time.Hour), nil).Cert
+	serial := strings.ToUpper(leaf.SerialNumber.Text(16))
+
+	tests := []struct {
+		name    string
+		remote  *uploader.Certificate
+		drifted bool
+	}{
+		{
+			name: "match",
+			remote: &uploader.Certificate{
+				ExpireTime:   leaf.NotAfter,
+				SerialNumber: "00:" + serial[:2] + ":" + serial[2:],
+				Hosts:        []string{"EXAMPLE.COM"},
+			},
+		},
+		{
+			name:   "no details",
+			remote: &uploader.Certificate{},
+		},
+		{
+			name:    "expiration time changed",
+			remote:  &uploader.Certificate{ExpireTime: leaf.NotAfter.Add(time.Hour)},
+			drifted: true,
+		},
+		{
+			name:    "serial number changed",
+			remote:  &uploader.Certificate{SerialNumber: "1234"},
+			drifted: true,
+		},
+		{
+			name:    "hosts changed",
+			remote:  &uploader.Certificate{Hosts: []string{"example.com", "www.example.com"}},
+			drifted: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			drift := certificateDrift(test.remote, leaf)
+
+			if actual := drift != ""; actual != test.drifted {
+				t.Fatalf("expected drifted to be %v, got %q", test.drifted, drift)
+			}
+		})
+	}
+}
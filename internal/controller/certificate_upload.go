@@ -3,70 +3,290 @@ package controller
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/tommy351/cert-uploader/internal/uploader"
 	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
-	ReasonCertNotFound     = "CertNotFound"
-	ReasonInvalidCertType  = "InvalidCertType"
-	ReasonCertUnchanged    = "CertUnchanged"
-	ReasonAPITokenNotFound = "APITokenNotFound"
-	ReasonUploaded         = "Uploaded"
-	ReasonFailed           = "Failed"
+	ReasonCertNotFound            = "CertNotFound"
+	ReasonInvalidCertType         = "InvalidCertType"
+	ReasonCertUnchanged           = "CertUnchanged"
+	ReasonAPITokenNotFound        = "APITokenNotFound"
+	ReasonUploaded                = "Uploaded"
+	ReasonFailed                  = "Failed"
+	ReasonDeleted                 = "Deleted"
+	ReasonDeleteFailed            = "DeleteFailed"
+	ReasonValid                   = "Valid"
+	ReasonNoProvider              = "NoProvider"
+	ReasonPending                 = "Pending"
+	ReasonInvalidCredentials      = "InvalidCredentials"
+	ReasonInsufficientPermissions = "InsufficientPermissions"
+	ReasonProviderDisabled        = "ProviderDisabled"
+	ReasonDrifted                 = "Drifted"
+	ReasonInSync                  = "InSync"
+	ReasonInvalidCertificate      = "InvalidCertificate"
+	ReasonInvalidPrivateKey       = "InvalidPrivateKey"
+	ReasonKeyMismatch             = "KeyMismatch"
+	ReasonInvalidChain            = "InvalidChain"
+	ReasonCertExpired             = "CertExpired"
+	ReasonCertNotYetValid         = "CertNotYetValid"
+	ReasonHostsNotCovered         = "HostsNotCovered"
+	ReasonProviderNotFound        = "ProviderNotFound"
+	ReasonInvalidProvider         = "InvalidProvider"
+	ReasonUploadExists            = "UploadExists"
+	ReasonInvalidAnnotation       = "InvalidAnnotation"
+	ReasonCertificateNotFound     = "CertificateNotFound"
+	ReasonCertificateNotReady     = "CertificateNotReady"
+	ReasonActivated               = "Activated"
+	ReasonRolledOver              = "RolledOver"
+	ReasonRolledBack              = "RolledBack"
+	ReasonRetrying                = "Retrying"
+	ReasonMultipleProviders       = "MultipleProviders"
+	ReasonNamespaceNotWatched     = "NamespaceNotWatched"
+)
+
+const (
+	FinalizerName = "cert-uploader.dev/finalizer"
+
+	// AnnotationForceUpload uploads the certificate to all targets even if the
+	// secret is not changed, when the value is changed. A timestamp is usually
+	// used as the value.
+	AnnotationForceUpload = "cert-uploader.dev/force-upload"
 )
 
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch;update
-// +kubebuilder:rbac:groups=cert-uploader.dev,resources=certificateuploads,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=certificateuploads,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=cert-uploader.dev,resources=certificateuploads/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=certificateuploads/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=providers;clusterproviders,verbs=get;list;watch
 
 type CertificateUploadReconciler struct {
 	Client        client.Client
 	EventRecorder record.EventRecorder
+	Uploaders     *uploader.Registry
+
+	// ResyncInterval is how often uploaded certificates are compared with
+	// secrets. Resync is disabled if it is zero.
+	ResyncInterval time.Duration
+
+	// ClusterResourceNamespace is where secrets referenced by ClusterProviders
+	// and ClusterCertificateUploads are read from.
+	ClusterResourceNamespace string
+
+	// MaxConcurrentReconciles is the maximum number of concurrent reconciles
+	// of each controller. It defaults to 1.
+	MaxConcurrentReconciles int
+
+	// Selector restricts CertificateUploads and ClusterCertificateUploads
+	// reconciled by the controller. All of them are reconciled if it is nil.
+	Selector labels.Selector
+
+	// SecretSelector restricts secrets watched for changes. All secrets are
+	// watched if it is nil.
+	SecretSelector labels.Selector
+}
+
+// uploadObject is a resource uploading a secret, i.e. a CertificateUpload or a
+// ClusterCertificateUpload.
+type uploadObject interface {
+	client.Object
+
+	GetUploadSpec() *v1alpha1.CertificateUploadSpec
+	GetUploadStatus() *v1alpha1.CertificateUploadStatus
+	GetSecretKey() types.NamespacedName
 }
 
 func (r *CertificateUploadReconciler) SetupWithManager(mgr manager.Manager) error {
-	return builder.
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), new(v1alpha1.CertificateUpload), providerRefField, r.indexProviderRefs); err != nil {
+		return fmt.Errorf("index failed: %w", err)
+	}
+
+	// Providers are indexed once for both CertificateUploads and
+	// ClusterCertificateUploads.
+	for _, object := range []client.Object{new(v1alpha1.Provider), new(v1alpha1.ClusterProvider)} {
+		if err := mgr.GetFieldIndexer().IndexField(context.TODO(), object, credentialsSecretField, r.indexCredentialsSecrets); err != nil {
+			return fmt.Errorf("index failed: %w", err)
+		}
+	}
+
+	b := builder.
 		ControllerManagedBy(mgr).
-		For(&v1alpha1.CertificateUpload{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
-		Complete(r)
+		For(&v1alpha1.CertificateUpload{}, builder.WithPredicates(r.uploadPredicate())).
+		WithOptions(r.controllerOptions()).
+		Watches(&source.Kind{Type: &v1alpha1.Provider{}}, handler.EnqueueRequestsFromMapFunc(r.mapProvider), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &v1alpha1.ClusterProvider{}}, handler.EnqueueRequestsFromMapFunc(r.mapProvider), builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+
+	b, err := watchSecrets(mgr, b, new(v1alpha1.CertificateUpload), r.mapSecret, r.SecretSelector)
+	if err != nil {
+		return err
+	}
+
+	// Status of Certificates is watched, so the predicate is not applied.
+	b, err = watchCertificates(mgr, b, new(v1alpha1.CertificateUpload), r.mapCertificate)
+	if err != nil {
+		return err
+	}
+
+	return b.Complete(r)
+}
+
+func (r *CertificateUploadReconciler) controllerOptions() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+	}
 }
 
 func (r *CertificateUploadReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	cu := new(v1alpha1.CertificateUpload)
+	return r.reconcile(ctx, req, new(v1alpha1.CertificateUpload))
+}
 
+func (r *CertificateUploadReconciler) reconcile(ctx context.Context, req reconcile.Request, cu uploadObject) (reconcile.Result, error) {
 	if err := r.Client.Get(ctx, req.NamespacedName, cu); err != nil {
+		if errors.IsNotFound(err) {
+			deleteExpireTimeMetrics(newMetricsKey(uploadKind(cu), req.NamespacedName))
+		}
+
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Resources not matching the selector are left to other instances
+	if !matchesSelector(r.Selector, cu) {
+		return reconcile.Result{}, nil
+	}
+
+	if !cu.GetDeletionTimestamp().IsZero() {
+		return r.finalize(ctx, cu)
+	}
+
+	if !controllerutil.ContainsFinalizer(cu, FinalizerName) {
+		controllerutil.AddFinalizer(cu, FinalizerName)
+
+		if err := r.Client.Update(ctx, cu); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+	}
+
 	return r.upload(ctx, cu)
 }
 
-func (r *CertificateUploadReconciler) upload(ctx context.Context, cu *v1alpha1.CertificateUpload) (reconcile.Result, error) {
+func (r *CertificateUploadReconciler) finalize(ctx context.Context, cu uploadObject) (reconcile.Result, error) {
+	if !controllerutil.ContainsFinalizer(cu, FinalizerName) {
+		return reconcile.Result{}, nil
+	}
+
+	if cu.GetUploadSpec().DeletionPolicy != v1alpha1.DeletionPolicyRetain {
+		targets := uploadTargets(cu)
+		targets = append(targets, removedTargets(cu, targetNames(targets))...)
+
+		for i := range targets {
+			if err := r.deleteTarget(ctx, cu, &targets[i]); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
+
+	controllerutil.RemoveFinalizer(cu, FinalizerName)
+
+	if err := r.Client.Update(ctx, cu); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to remove finalizer: %w", err)
+	}
+
+	deleteExpireTimeMetrics(uploadMetricsKey(cu))
+
+	return reconcile.Result{}, nil
+}
+
+func (r *CertificateUploadReconciler) upload(ctx context.Context, cu uploadObject) (reconcile.Result, error) {
+	if cu.GetUploadSpec().Suspend {
+		log.FromContext(ctx).V(1).Info("Skip because the resource is suspended")
+
+		// Uploaded certificates still expire, e.g. after the controller is
+		// restarted while the resource is suspended.
+		updateExpireTimeMetrics(cu)
+
+		return reconcile.Result{}, nil
+	}
+
+	original := cu.GetUploadStatus().DeepCopy()
+	result, err := r.uploadCertificate(ctx, cu)
+
+	if interval := r.resyncInterval(cu); interval > 0 && err == nil && result.IsZero() {
+		result.RequeueAfter = interval
+	}
+
+	setReadyCondition(cu)
+	updateExpireTimeMetrics(cu)
+	cu.GetUploadStatus().ObservedGeneration = cu.GetGeneration()
+
+	if equality.Semantic.DeepEqual(original, cu.GetUploadStatus()) {
+		return result, err
+	}
+
+	if updateErr := r.Client.Status().Update(ctx, cu); updateErr != nil {
+		r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonFailed, "Failed to update status: %v", updateErr)
+
+		return reconcile.Result{}, fmt.Errorf("failed to update resource status: %w", updateErr)
+	}
+
+	return result, err
+}
+
+func (r *CertificateUploadReconciler) uploadCertificate(ctx context.Context, cu uploadObject) (reconcile.Result, error) {
 	logger := log.FromContext(ctx)
 	cert := new(corev1.Secret)
-	certKey := types.NamespacedName{
-		Namespace: cu.Namespace,
-		Name:      cu.Spec.SecretName,
+
+	var certificate *unstructured.Unstructured
+
+	if cu.GetUploadSpec().CertificateRef != nil {
+		var (
+			requeueAfter time.Duration
+			err          error
+		)
+
+		if certificate, requeueAfter, err = r.getCertificate(ctx, cu); certificate == nil {
+			return reconcile.Result{RequeueAfter: requeueAfter}, err
+		}
 	}
 
+	certKey := secretKey(cu)
+
 	if err := r.Client.Get(ctx, certKey, cert); err != nil {
 		if errors.IsNotFound(err) {
 			logger.Error(err, "Secret does not exist")
 			r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonCertNotFound, "Secret %q does not exist", certKey)
+			setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionFalse, ReasonCertNotFound, fmt.Sprintf("Secret %q does not exist", certKey))
+
+			return reconcile.Result{}, nil
+		}
+
+		// Secrets of ClusterCertificateUploads may be in namespaces which are
+		// not watched. They never show up in the cache, so don't retry.
+		if isNamespaceNotWatched(err) {
+			logger.Error(err, "Secret is in a namespace which is not watched")
+			r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonNamespaceNotWatched, "Namespace of secret %q is not watched", certKey)
+			setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionFalse, ReasonNamespaceNotWatched, fmt.Sprintf("Namespace of secret %q is not watched", certKey))
 
 			return reconcile.Result{}, nil
 		}
@@ -77,22 +297,280 @@ func (r *CertificateUploadReconciler) upload(ctx context.Context, cu *v1alpha1.C
 	if cert.Type != corev1.SecretTypeTLS {
 		logger.Info("Secret type must be kubernetes.io/tls")
 		r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonInvalidCertType, "Type of secret %q is not %s", certKey, corev1.SecretTypeTLS)
+		setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionFalse, ReasonInvalidCertType, fmt.Sprintf("Type of secret %q is not %s", certKey, corev1.SecretTypeTLS))
 
 		return reconcile.Result{}, nil
 	}
 
-	if cu.Status.SecretResourceVersion == cert.ResourceVersion {
-		logger.V(1).Info("Skip because the resource version is not changed")
-		r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonCertUnchanged, `Skip because secret "%s/%s" not changed`, cert.Namespace, cert.Name)
+	setCondition(cu, v1alpha1.ConditionSecretValid, metav1.ConditionTrue, ReasonValid, "")
+
+	now := time.Now()
+	leaf, certErr := validateCertificate(cert, now)
+
+	if leaf != nil {
+		setSecretExpireTimeMetric(cu, leaf.NotAfter)
+	}
+
+	if certErr != nil {
+		logger.Info("Certificate is invalid", "reason", certErr.Reason, "message", certErr.Message)
+		r.EventRecorder.Event(cu, corev1.EventTypeWarning, certErr.Reason, certErr.Message)
+		setCondition(cu, v1alpha1.ConditionCertificateValid, metav1.ConditionFalse, certErr.Reason, certErr.Message)
+
+		// Try again when the certificate becomes valid
+		if certErr.Reason == ReasonCertNotYetValid {
+			return reconcile.Result{RequeueAfter: leaf.NotBefore.Sub(now)}, nil
+		}
 
 		return reconcile.Result{}, nil
 	}
 
-	if cu.Spec.Cloudflare != nil {
-		return r.uploadToCloudflare(ctx, cu, cert)
+	targets := uploadTargets(cu)
+	failures, retryErr := r.removeTargets(ctx, cu, targets)
+
+	if len(targets) == 0 {
+		if len(failures) > 0 {
+			setUploadConditions(cu, failures)
+
+			return reconcile.Result{}, retryErr
+		}
+
+		setCondition(cu, v1alpha1.ConditionCertificateValid, metav1.ConditionTrue, ReasonValid, "")
+		setCondition(cu, v1alpha1.ConditionUploaded, metav1.ConditionFalse, ReasonNoProvider, "No target is configured")
+
+		return reconcile.Result{}, nil
 	}
 
-	return reconcile.Result{}, nil
+	var (
+		unchanged    = len(failures) == 0
+		drifts       []string
+		requeueAfter time.Duration
+		uploaded     bool
+		certSource   = &certificateSource{
+			Secret:      cert,
+			Leaf:        leaf,
+			Fingerprint: secretFingerprint(cert),
+		}
+	)
+
+	resyncDue := r.resyncDue(cu, now)
+	force := forceUploadRequested(cu)
+
+	if force {
+		logger.Info("Upload is forced by the annotation", "value", cu.GetAnnotations()[AnnotationForceUpload])
+	}
+
+	for i := range targets {
+		target := &targets[i]
+		status := targetStatus(cu, target.Name)
+
+		// Resource version is changed when only metadata of the secret is
+		// changed, or the secret is recreated with the same content.
+		if status.SecretResourceVersion != cert.ResourceVersion && status.SecretFingerprint == certSource.Fingerprint {
+			status.SecretResourceVersion = cert.ResourceVersion
+		}
+
+		// Wait until the provider is available again
+		if t := status.NextRetryTime; t != nil && now.Before(t.Time) && !force {
+			unchanged = false
+			failures = append(failures, &uploadFailure{
+				Reason:  ReasonRetrying,
+				Message: status.LastError,
+			})
+			requeueAfter = minRequeueAfter(requeueAfter, t.Sub(now))
+
+			continue
+		}
+
+		pending := status.SecretResourceVersion != cert.ResourceVersion || status.UploadPending || force
+
+		if status.PreviousCertificateID != "" && !pending {
+			unchanged = false
+
+			after, failure, err := r.continueRollover(ctx, cu, target, status)
+			if err != nil {
+				if retryErr == nil {
+					retryErr = err
+				}
+
+				failures = append(failures, &uploadFailure{
+					Reason:  ReasonFailed,
+					Message: status.LastError,
+				})
+
+				continue
+			}
+
+			if failure != nil {
+				failures = append(failures, failure)
+				after = minRequeueAfter(after, failure.RetryAfter)
+			}
+
+			requeueAfter = minRequeueAfter(requeueAfter, after)
+
+			continue
+		}
+
+		// Keep reporting the rollback instead of uploading the same secret again
+		if !pending && status.RolledBackFingerprint != "" && status.RolledBackFingerprint == certSource.Fingerprint {
+			unchanged = false
+			failures = append(failures, &uploadFailure{
+				Reason:  ReasonRolledBack,
+				Message: status.LastError,
+			})
+
+			continue
+		}
+
+		if !pending {
+			if !resyncDue {
+				continue
+			}
+
+			drift, err := r.checkTargetDrift(ctx, cu, leaf, target, status)
+			if err != nil {
+				logger.Error(err, "Failed to check drift", "target", target.Name)
+
+				continue
+			}
+
+			if drift == "" {
+				continue
+			}
+
+			message := fmt.Sprintf("Certificate of target %q drifted: %s", target.Name, drift)
+			r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonDrifted, message)
+
+			if cu.GetUploadSpec().DriftPolicy == v1alpha1.DriftPolicyReport {
+				drifts = append(drifts, message)
+
+				continue
+			}
+		}
+
+		unchanged = false
+		uploaded = true
+
+		// Retry until the upload succeeds, even if the secret is not changed
+		status.UploadPending = true
+
+		failure, err := r.uploadTarget(ctx, cu, certSource, target, status)
+		if err != nil {
+			// Continue uploading to other targets and retry later
+			if retryErr == nil {
+				retryErr = err
+			}
+
+			failures = append(failures, &uploadFailure{
+				Reason:  ReasonFailed,
+				Message: status.LastError,
+			})
+
+			continue
+		}
+
+		if failure != nil {
+			failures = append(failures, failure)
+			requeueAfter = minRequeueAfter(requeueAfter, failure.RetryAfter)
+		}
+
+		if status.PreviousCertificateID != "" {
+			requeueAfter = minRequeueAfter(requeueAfter, rolloverPollInterval)
+		}
+	}
+
+	// Targets failed to upload are pending and retried, so the request is
+	// handled even if some uploads failed.
+	if force {
+		cu.GetUploadStatus().LastForceUpload = cu.GetAnnotations()[AnnotationForceUpload]
+	}
+
+	if resyncDue {
+		cu.GetUploadStatus().LastSyncTime = timePtr(metav1.NewTime(now))
+		setDriftCondition(cu, drifts)
+	}
+
+	if unchanged {
+		cu.GetUploadStatus().SecretResourceVersion = cert.ResourceVersion
+		cu.GetUploadStatus().SecretFingerprint = certSource.Fingerprint
+		setCondition(cu, v1alpha1.ConditionCertificateValid, metav1.ConditionTrue, ReasonValid, "")
+
+		if !resyncDue {
+			logger.V(1).Info("Skip because the resource version is not changed")
+			r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonCertUnchanged, `Skip because secret "%s/%s" not changed`, cert.Namespace, cert.Name)
+		}
+
+		return reconcile.Result{}, nil
+	}
+
+	setUploadConditions(cu, failures)
+
+	if uploaded {
+		r.recordCertificateEvent(cu, certificate, failures)
+	}
+
+	if len(failures) == 0 {
+		cu.GetUploadStatus().SecretResourceVersion = cert.ResourceVersion
+		cu.GetUploadStatus().SecretFingerprint = certSource.Fingerprint
+	}
+
+	return reconcile.Result{RequeueAfter: requeueAfter}, retryErr
+}
+
+func (r *CertificateUploadReconciler) resyncInterval(cu uploadObject) time.Duration {
+	if cu.GetUploadSpec().ResyncInterval != nil {
+		return cu.GetUploadSpec().ResyncInterval.Duration
+	}
+
+	return r.ResyncInterval
+}
+
+func (r *CertificateUploadReconciler) resyncDue(cu uploadObject, now time.Time) bool {
+	interval := r.resyncInterval(cu)
+
+	if interval <= 0 {
+		return false
+	}
+
+	return cu.GetUploadStatus().LastSyncTime == nil || !now.Before(cu.GetUploadStatus().LastSyncTime.Add(interval))
+}
+
+// forceUploadRequested returns true if the force-upload annotation is changed
+// since it was handled last time.
+func forceUploadRequested(cu uploadObject) bool {
+	value := cu.GetAnnotations()[AnnotationForceUpload]
+
+	return value != "" && value != cu.GetUploadStatus().LastForceUpload
+}
+
+// uploadPredicate passes events of resources whose spec or force-upload
+// annotation is changed.
+func (r *CertificateUploadReconciler) uploadPredicate() predicate.Predicate {
+	return predicate.And(
+		selectorPredicate(r.Selector),
+		predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return e.ObjectOld.GetAnnotations()[AnnotationForceUpload] != e.ObjectNew.GetAnnotations()[AnnotationForceUpload]
+				},
+			},
+			// Resources may start matching the selector
+			predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return r.Selector != nil && !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+				},
+			},
+		),
+	)
+}
+
+// minRequeueAfter returns the shorter positive duration.
+func minRequeueAfter(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+
+	return a
 }
 
 func timePtr(t metav1.Time) *metav1.Time {
@@ -0,0 +1,291 @@
+package controller_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tommy351/cert-uploader/internal/controller"
+	"github.com/tommy351/cert-uploader/internal/uploader/cloudflaretest"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	timeout  = 30 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+// conditionState is the part of a condition checked in tests.
+type conditionState struct {
+	Status metav1.ConditionStatus
+	Reason string
+}
+
+var _ = Describe("CertificateUploadReconciler", func() {
+	var (
+		ctx         context.Context
+		namespace   string
+		cu          *v1alpha1.CertificateUpload
+		tokenSecret *corev1.Secret
+	)
+
+	getUpload := func() *v1alpha1.CertificateUpload {
+		result := new(v1alpha1.CertificateUpload)
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cu), result)).To(Succeed())
+
+		return result
+	}
+
+	getCondition := func(conditionType string) func() conditionState {
+		return func() conditionState {
+			cond := meta.FindStatusCondition(getUpload().Status.Conditions, conditionType)
+			if cond == nil {
+				return conditionState{}
+			}
+
+			return conditionState{Status: cond.Status, Reason: cond.Reason}
+		}
+	}
+
+	getCertificateID := func() string {
+		for _, t := range getUpload().Status.Targets {
+			if t.Name == "cloudflare" {
+				return t.CertificateID
+			}
+		}
+
+		return ""
+	}
+
+	uploadedCertificate := func(id string) func() string {
+		return func() string {
+			if cert := fakeAPI.Certificate(id); cert != nil {
+				return cert.Certificate
+			}
+
+			return ""
+		}
+	}
+
+	waitForReady := func() string {
+		Eventually(getCondition(v1alpha1.ConditionReady), timeout, interval).Should(Equal(conditionState{
+			Status: metav1.ConditionTrue,
+			Reason: controller.ReasonUploaded,
+		}))
+
+		id := getCertificateID()
+		Expect(id).NotTo(BeEmpty())
+
+		return id
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+		}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+
+		tokenSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "cloudflare",
+			},
+			StringData: map[string]string{
+				"token": cloudflaretest.APIToken,
+			},
+		}
+
+		cu = &v1alpha1.CertificateUpload{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "test",
+			},
+			Spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "tls",
+				Targets: []v1alpha1.UploadTarget{
+					{
+						Name: "cloudflare",
+						Cloudflare: &v1alpha1.CloudflareUploadSpec{
+							ZoneID: cloudflaretest.ZoneID,
+							APITokenSecretRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: tokenSecret.Name},
+								Key:                  "token",
+							},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(k8sClient.Create(ctx, tokenSecret)).To(Succeed())
+		Expect(k8sClient.Create(ctx, cu)).To(Succeed())
+	})
+
+	When("the secret exists", func() {
+		var secret *corev1.Secret
+
+		BeforeEach(func() {
+			secret = newTLSSecret(namespace, "tls")
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		})
+
+		It("should upload the certificate", func() {
+			id := waitForReady()
+			Expect(uploadedCertificate(id)()).To(Equal(string(secret.Data[corev1.TLSCertKey])))
+			Expect(getUpload().Finalizers).To(ContainElement(controller.FinalizerName))
+		})
+
+		It("should update the certificate when the secret is changed", func() {
+			id := waitForReady()
+
+			updated := newTLSSecret(namespace, "tls")
+			secret.Data = updated.Data
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+			Eventually(uploadedCertificate(id), timeout, interval).Should(Equal(string(updated.Data[corev1.TLSCertKey])))
+			Expect(fakeAPI.Updates(id)).To(Equal(1))
+			Expect(getCertificateID()).To(Equal(id))
+		})
+
+		It("should update the certificate when the upload is forced", func() {
+			id := waitForReady()
+
+			latest := getUpload()
+			latest.Annotations = map[string]string{
+				controller.AnnotationForceUpload: time.Now().Format(time.RFC3339),
+			}
+			Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+
+			Eventually(func() int {
+				return fakeAPI.Updates(id)
+			}, timeout, interval).Should(Equal(1))
+		})
+
+		It("should delete the certificate when the resource is deleted", func() {
+			id := waitForReady()
+
+			Expect(k8sClient.Delete(ctx, cu)).To(Succeed())
+
+			Eventually(func() *cloudflaretest.Certificate {
+				return fakeAPI.Certificate(id)
+			}, timeout, interval).Should(BeNil())
+		})
+	})
+
+	When("the secret does not exist", func() {
+		It("should wait for the secret", func() {
+			Eventually(getCondition(v1alpha1.ConditionSecretValid), timeout, interval).Should(Equal(conditionState{
+				Status: metav1.ConditionFalse,
+				Reason: controller.ReasonCertNotFound,
+			}))
+
+			secret := newTLSSecret(namespace, "tls")
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+			id := waitForReady()
+			Expect(uploadedCertificate(id)()).To(Equal(string(secret.Data[corev1.TLSCertKey])))
+		})
+	})
+
+	When("the secret is not a TLS secret", func() {
+		BeforeEach(func() {
+			secret := newTLSSecret(namespace, "tls")
+			secret.Type = corev1.SecretTypeOpaque
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		})
+
+		It("should not upload the secret", func() {
+			Eventually(getCondition(v1alpha1.ConditionSecretValid), timeout, interval).Should(Equal(conditionState{
+				Status: metav1.ConditionFalse,
+				Reason: controller.ReasonInvalidCertType,
+			}))
+			Expect(getCertificateID()).To(BeEmpty())
+		})
+	})
+
+	When("the API token is invalid", func() {
+		BeforeEach(func() {
+			tokenSecret.StringData["token"] = "invalid-token"
+			Expect(k8sClient.Create(ctx, newTLSSecret(namespace, "tls"))).To(Succeed())
+		})
+
+		It("should report invalid credentials", func() {
+			Eventually(getCondition(v1alpha1.ConditionCredentialsValid), timeout, interval).Should(Equal(conditionState{
+				Status: metav1.ConditionFalse,
+				Reason: controller.ReasonInvalidCredentials,
+			}))
+			Expect(getCertificateID()).To(BeEmpty())
+			Expect(getCondition(v1alpha1.ConditionReady)().Status).To(Equal(metav1.ConditionFalse))
+		})
+	})
+
+	When("the API token secret does not exist", func() {
+		JustBeforeEach(func() {
+			Expect(k8sClient.Delete(ctx, tokenSecret)).To(Succeed())
+			Expect(k8sClient.Create(ctx, newTLSSecret(namespace, "tls"))).To(Succeed())
+		})
+
+		It("should report invalid credentials", func() {
+			Eventually(getCondition(v1alpha1.ConditionCredentialsValid), timeout, interval).Should(Equal(conditionState{
+				Status: metav1.ConditionFalse,
+				Reason: controller.ReasonInvalidCredentials,
+			}))
+		})
+	})
+})
+
+// newTLSSecret returns a TLS secret containing a new self-signed certificate
+// for the test zone.
+func newTLSSecret(namespace, name string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	Expect(err).NotTo(HaveOccurred())
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cloudflaretest.ZoneName},
+		DNSNames:     []string{cloudflaretest.ZoneName, "*." + cloudflaretest.ZoneName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
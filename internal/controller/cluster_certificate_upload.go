@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=clustercertificateuploads,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=clustercertificateuploads/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=clustercertificateuploads/finalizers,verbs=update
+
+type ClusterCertificateUploadReconciler struct {
+	CertificateUploadReconciler *CertificateUploadReconciler
+}
+
+func (r *ClusterCertificateUploadReconciler) SetupWithManager(mgr manager.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), new(v1alpha1.ClusterCertificateUpload), providerRefField, r.CertificateUploadReconciler.indexProviderRefs); err != nil {
+		return fmt.Errorf("index failed: %w", err)
+	}
+
+	b := builder.
+		ControllerManagedBy(mgr).
+		For(&v1alpha1.ClusterCertificateUpload{}, builder.WithPredicates(r.CertificateUploadReconciler.uploadPredicate())).
+		WithOptions(r.CertificateUploadReconciler.controllerOptions()).
+		Watches(&source.Kind{Type: &v1alpha1.Provider{}}, handler.EnqueueRequestsFromMapFunc(r.mapProvider), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &v1alpha1.ClusterProvider{}}, handler.EnqueueRequestsFromMapFunc(r.mapProvider), builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+
+	b, err := watchSecrets(mgr, b, new(v1alpha1.ClusterCertificateUpload), r.mapSecret, r.CertificateUploadReconciler.SecretSelector)
+	if err != nil {
+		return err
+	}
+
+	b, err = watchCertificates(mgr, b, new(v1alpha1.ClusterCertificateUpload), r.mapCertificate)
+	if err != nil {
+		return err
+	}
+
+	return b.Complete(r)
+}
+
+func (r *ClusterCertificateUploadReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	return r.CertificateUploadReconciler.reconcile(ctx, req, new(v1alpha1.ClusterCertificateUpload))
+}
+
+// mapProvider returns requests of ClusterCertificateUploads referring to a
+// Provider or a ClusterProvider.
+func (r *ClusterCertificateUploadReconciler) mapProvider(object client.Object) []reconcile.Request {
+	return r.CertificateUploadReconciler.providerRequests(object, new(v1alpha1.ClusterCertificateUploadList))
+}
+
+// mapSecret returns requests of ClusterCertificateUploads uploading a secret or
+// referring to providers whose credentials are stored in it.
+func (r *ClusterCertificateUploadReconciler) mapSecret(object client.Object) []reconcile.Request {
+	return append(
+		r.CertificateUploadReconciler.secretRequests(object, new(v1alpha1.ClusterCertificateUploadList)),
+		r.CertificateUploadReconciler.credentialsRequests(object, new(v1alpha1.ClusterCertificateUploadList))...,
+	)
+}
+
+// mapCertificate returns requests of ClusterCertificateUploads referring to a
+// Certificate.
+func (r *ClusterCertificateUploadReconciler) mapCertificate(object client.Object) []reconcile.Request {
+	return r.CertificateUploadReconciler.certificateRequests(object, new(v1alpha1.ClusterCertificateUploadList))
+}
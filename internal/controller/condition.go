@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readyDependencies are the conditions which must be true for a
+// CertificateUpload to be ready, in the order they are evaluated.
+// nolint: gochecknoglobals
+var readyDependencies = []string{
+	v1alpha1.ConditionSecretValid,
+	v1alpha1.ConditionCertificateValid,
+	v1alpha1.ConditionCredentialsValid,
+	v1alpha1.ConditionUploaded,
+}
+
+func setCondition(cu uploadObject, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&cu.GetUploadStatus().Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: cu.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setFailureCondition sets a condition to false with the first failure caused
+// by it, or true if there is none.
+func setFailureCondition(cu uploadObject, conditionType string, failures []*uploadFailure) {
+	for _, f := range failures {
+		if f.Condition == conditionType {
+			setCondition(cu, conditionType, metav1.ConditionFalse, f.Reason, f.Message)
+
+			return
+		}
+	}
+
+	setCondition(cu, conditionType, metav1.ConditionTrue, ReasonValid, "")
+}
+
+func setUploadConditions(cu uploadObject, failures []*uploadFailure) {
+	setFailureCondition(cu, v1alpha1.ConditionCertificateValid, failures)
+	setFailureCondition(cu, v1alpha1.ConditionCredentialsValid, failures)
+
+	if len(failures) > 0 {
+		messages := make([]string, len(failures))
+
+		for i, f := range failures {
+			messages[i] = f.Message
+		}
+
+		setCondition(cu, v1alpha1.ConditionUploaded, metav1.ConditionFalse, failures[0].Reason, strings.Join(messages, "; "))
+	} else {
+		setCondition(cu, v1alpha1.ConditionUploaded, metav1.ConditionTrue, ReasonUploaded, "")
+	}
+}
+
+func setDriftCondition(cu uploadObject, drifts []string) {
+	if len(drifts) == 0 {
+		setCondition(cu, v1alpha1.ConditionDrifted, metav1.ConditionFalse, ReasonInSync, "")
+
+		return
+	}
+
+	setCondition(cu, v1alpha1.ConditionDrifted, metav1.ConditionTrue, ReasonDrifted, strings.Join(drifts, "; "))
+}
+
+func setReadyCondition(cu uploadObject) {
+	for _, t := range readyDependencies {
+		if cond := meta.FindStatusCondition(cu.GetUploadStatus().Conditions, t); cond != nil && cond.Status == metav1.ConditionFalse {
+			setCondition(cu, v1alpha1.ConditionReady, metav1.ConditionFalse, cond.Reason, cond.Message)
+
+			return
+		}
+	}
+
+	if cond := meta.FindStatusCondition(cu.GetUploadStatus().Conditions, v1alpha1.ConditionDrifted); cond != nil && cond.Status == metav1.ConditionTrue {
+		setCondition(cu, v1alpha1.ConditionReady, metav1.ConditionFalse, cond.Reason, cond.Message)
+
+		return
+	}
+
+	for _, t := range readyDependencies {
+		if !meta.IsStatusConditionTrue(cu.GetUploadStatus().Conditions, t) {
+			setCondition(cu, v1alpha1.ConditionReady, metav1.ConditionUnknown, ReasonPending, "Waiting for condition "+t)
+
+			return
+		}
+	}
+
+	setCondition(cu, v1alpha1.ConditionReady, metav1.ConditionTrue, ReasonUploaded, "")
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	cacheSyncTimeout = time.Second
+	pingTimeout      = 5 * time.Second
+)
+
+var errCacheNotSynced = errors.New("cache is not synced")
+
+// CacheSyncCheck returns a readiness check which fails until informers of the
+// cache are synced.
+func CacheSyncCheck(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncTimeout)
+		defer cancel()
+
+		if !c.WaitForCacheSync(ctx) {
+			return errCacheNotSynced
+		}
+
+		return nil
+	}
+}
+
+// ProviderCheck returns a readiness check which fails when the API of an
+// enabled provider is unreachable.
+func ProviderCheck(uploaders *uploader.Registry) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), pingTimeout)
+		defer cancel()
+
+		for _, name := range uploaders.Names() {
+			if !uploaders.Enabled(name) {
+				continue
+			}
+
+			pinger, ok := uploaders.Get(name).(uploader.Pinger)
+			if !ok {
+				continue
+			}
+
+			if err := pinger.Ping(ctx); err != nil {
+				return fmt.Errorf("provider %s is unreachable: %w", name, err)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// AnnotationProvider is a comma-separated list of providers which a TLS
+	// secret is uploaded to. A CertificateUpload with the same name as the
+	// secret is created and owned by the secret.
+	AnnotationProvider = "cert-uploader.dev/provider"
+
+	// AnnotationProviderKind is the kind of providers in AnnotationProvider,
+	// either Provider or ClusterProvider. The default is Provider.
+	AnnotationProviderKind = "cert-uploader.dev/provider-kind"
+
+	// controllerLabelPrefix is the prefix of labels owned by the controller,
+	// which are kept when labels of a managed CertificateUpload are replaced.
+	controllerLabelPrefix = "cert-uploader.dev/"
+)
+
+var ErrUnmanagedCertificateUpload = errors.New("certificate upload is not managed by the secret")
+
+// reconcileManagedUpload creates, updates or deletes the CertificateUpload
+// managed by annotations of a secret.
+func (r *SecretReconciler) reconcileManagedUpload(ctx context.Context, secret *corev1.Secret) error {
+	logger := log.FromContext(ctx)
+	cu := &v1alpha1.CertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secret.Namespace,
+			Name:      secret.Name,
+		},
+	}
+
+	if _, ok := secret.Annotations[AnnotationProvider]; !ok || !secret.DeletionTimestamp.IsZero() {
+		return r.deleteManagedUpload(ctx, secret)
+	}
+
+	if secret.Type != corev1.SecretTypeTLS {
+		r.EventRecorder.Eventf(secret, corev1.EventTypeWarning, ReasonInvalidCertType, "Annotation %s is ignored because type of the secret is not %s", AnnotationProvider, corev1.SecretTypeTLS)
+
+		return nil
+	}
+
+	targets, err := managedTargets(secret)
+	if err != nil {
+		r.EventRecorder.Event(secret, corev1.EventTypeWarning, ReasonInvalidAnnotation, err.Error())
+
+		return nil
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, cu, func() error {
+		if !cu.CreationTimestamp.IsZero() && !metav1.IsControlledBy(cu, secret) {
+			return ErrUnmanagedCertificateUpload
+		}
+
+		// Copy labels so the upload matches the same selectors as the secret
+		cu.Labels = managedLabels(cu.Labels, secret.Labels)
+
+		cu.Spec.SecretName = secret.Name
+		cu.Spec.Targets = targets
+
+		return controllerutil.SetControllerReference(secret, cu, r.Client.Scheme())
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnmanagedCertificateUpload) {
+			r.EventRecorder.Eventf(secret, corev1.EventTypeWarning, ReasonUploadExists, "CertificateUpload %q already exists and is not managed by the secret", secret.Name)
+
+			return nil
+		}
+
+		return fmt.Errorf("failed to create or update certificate upload: %w", err)
+	}
+
+	if result != controllerutil.OperationResultNone {
+		logger.Info("Managed CertificateUpload is "+string(result), "certificateUpload", client.ObjectKeyFromObject(cu))
+	}
+
+	return nil
+}
+
+// deleteManagedUpload deletes the CertificateUpload owned by a secret if it
+// exists.
+func (r *SecretReconciler) deleteManagedUpload(ctx context.Context, secret *corev1.Secret) error {
+	cu := new(v1alpha1.CertificateUpload)
+	key := types.NamespacedName{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+	}
+
+	if err := r.Client.Get(ctx, key, cu); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if !metav1.IsControlledBy(cu, secret) || !cu.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	if err := r.Client.Delete(ctx, cu); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete certificate upload: %w", err)
+	}
+
+	log.FromContext(ctx).Info("Managed CertificateUpload is deleted", "certificateUpload", key)
+
+	return nil
+}
+
+// managedTargets returns targets of providers in annotations of a secret.
+// Targets are named after providers.
+func managedTargets(secret *corev1.Secret) ([]v1alpha1.UploadTarget, error) {
+	kind := secret.Annotations[AnnotationProviderKind]
+
+	switch kind {
+	case "":
+		kind = v1alpha1.ProviderKind
+	case v1alpha1.ProviderKind, v1alpha1.ClusterProviderKind:
+	default:
+		return nil, fmt.Errorf("annotation %s must be either %s or %s", AnnotationProviderKind, v1alpha1.ProviderKind, v1alpha1.ClusterProviderKind)
+	}
+
+	var targets []v1alpha1.UploadTarget
+
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(secret.Annotations[AnnotationProvider], ",") {
+		if name = strings.TrimSpace(name); name == "" || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		targets = append(targets, v1alpha1.UploadTarget{
+			Name: name,
+			ProviderRef: &v1alpha1.ProviderReference{
+				Kind: kind,
+				Name: name,
+			},
+		})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("annotation %s must contain at least one provider", AnnotationProvider)
+	}
+
+	return targets, nil
+}
+
+// managedLabels returns labels of a managed CertificateUpload, which are the
+// labels of the secret and the current labels owned by the controller. Labels
+// removed from the secret are removed from the upload too.
+func managedLabels(current, secretLabels map[string]string) map[string]string {
+	result := map[string]string{}
+
+	for k, v := range current {
+		if strings.HasPrefix(k, controllerLabelPrefix) {
+			result[k] = v
+		}
+	}
+
+	for k, v := range secretLabels {
+		result[k] = v
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
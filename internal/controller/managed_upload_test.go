@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestManagedTargets(t *testing.T) {
+	providerTarget := func(kind, name string) v1alpha1.UploadTarget {
+		return v1alpha1.UploadTarget{
+			Name: name,
+			ProviderRef: &v1alpha1.ProviderReference{
+				Kind: kind,
+				Name: name,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []v1alpha1.UploadTarget
+		err         bool
+	}{
+		{
+			name:        "single provider",
+			annotations: map[string]string{AnnotationProvider: "foo"},
+			expected:    []v1alpha1.UploadTarget{providerTarget(v1alpha1.ProviderKind, "foo")},
+		},
+		{
+			name:        "multiple providers",
+			annotations: map[string]string{AnnotationProvider: " foo, bar,,foo "},
+			expected: []v1alpha1.UploadTarget{
+				providerTarget(v1alpha1.ProviderKind, "foo"),
+				providerTarget(v1alpha1.ProviderKind, "bar"),
+			},
+		},
+		{
+			name: "cluster providers",
+			annotations: map[string]string{
+				AnnotationProvider:     "foo",
+				AnnotationProviderKind: v1alpha1.ClusterProviderKind,
+			},
+			expected: []v1alpha1.UploadTarget{providerTarget(v1alpha1.ClusterProviderKind, "foo")},
+		},
+		{
+			name: "invalid kind",
+			annotations: map[string]string{
+				AnnotationProvider:     "foo",
+				AnnotationProviderKind: "Secret",
+			},
+			err: true,
+		},
+		{
+			name:        "no providers",
+			annotations: map[string]string{AnnotationProvider: " , "},
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			targets, err := managedTargets(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+			})
+
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(targets, test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, targets)
+			}
+		})
+	}
+}
+
+func TestManagedLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string]string
+		secret   map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "copied from secret",
+			secret:   map[string]string{"app": "foo"},
+			expected: map[string]string{"app": "foo"},
+		},
+		{
+			name:     "removed from secret",
+			current:  map[string]string{"app": "foo", "env": "prod"},
+			secret:   map[string]string{"app": "bar"},
+			expected: map[string]string{"app": "bar"},
+		},
+		{
+			name:     "controller labels are kept",
+			current:  map[string]string{"app": "foo", "cert-uploader.dev/foo": "bar"},
+			expected: map[string]string{"cert-uploader.dev/foo": "bar"},
+		},
+		{
+			name:    "all removed",
+			current: map[string]string{"app": "foo"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if actual := managedLabels(test.current, test.secret); !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "cert_uploader"
+
+// nolint: gochecknoglobals
+var (
+	uploadAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_attempts_total",
+		Help:      "Total number of certificate uploads.",
+	}, []string{"provider"})
+
+	uploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_failures_total",
+		Help:      "Total number of failed certificate uploads.",
+	}, []string{"provider", "reason"})
+
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Duration of certificate uploads in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	certificateExpireTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "certificate_expire_time_seconds",
+		Help:      "Expiration time of the uploaded certificate in seconds since epoch.",
+	}, []string{"kind", "namespace", "name", "target"})
+
+	secretExpireTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_expire_time_seconds",
+		Help:      "Expiration time of the certificate in the secret in seconds since epoch.",
+	}, []string{"kind", "namespace", "name"})
+
+	// expireTimeTargets contains targets with expiration time metrics of each
+	// resource, so metrics of all targets are deleted with the resource.
+	expireTimeTargets   = map[metricsKey]map[string]bool{}
+	expireTimeTargetsMu sync.Mutex
+)
+
+// metricsKey identifies a CertificateUpload or a ClusterCertificateUpload in
+// metrics.
+type metricsKey struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func newMetricsKey(kind string, key types.NamespacedName) metricsKey {
+	return metricsKey{
+		Kind:      kind,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+	}
+}
+
+func uploadMetricsKey(cu uploadObject) metricsKey {
+	return metricsKey{
+		Kind:      uploadKind(cu),
+		Namespace: cu.GetNamespace(),
+		Name:      cu.GetName(),
+	}
+}
+
+// uploadKind returns the kind of a resource. The kind in TypeMeta is not
+// used because it is empty in objects read from the cache.
+func uploadKind(cu uploadObject) string {
+	if _, ok := cu.(*v1alpha1.ClusterCertificateUpload); ok {
+		return v1alpha1.ClusterCertificateUploadKind
+	}
+
+	return v1alpha1.CertificateUploadKind
+}
+
+// nolint: gochecknoinits
+func init() {
+	metrics.Registry.MustRegister(
+		uploadAttempts,
+		uploadFailures,
+		uploadDuration,
+		certificateExpireTime,
+		secretExpireTime,
+	)
+}
+
+func failureReason(failure *uploadFailure) string {
+	if failure != nil {
+		return failure.Reason
+	}
+
+	return ReasonFailed
+}
+
+// updateExpireTimeMetrics sets expiration time of certificates uploaded to
+// each target.
+func updateExpireTimeMetrics(cu uploadObject) {
+	key := uploadMetricsKey(cu)
+
+	expireTimeTargetsMu.Lock()
+	defer expireTimeTargetsMu.Unlock()
+
+	for _, status := range cu.GetUploadStatus().Targets {
+		if status.ExpireTime == nil {
+			continue
+		}
+
+		certificateExpireTime.WithLabelValues(key.Kind, key.Namespace, key.Name, status.Name).Set(float64(status.ExpireTime.Unix()))
+
+		if expireTimeTargets[key] == nil {
+			expireTimeTargets[key] = map[string]bool{}
+		}
+
+		expireTimeTargets[key][status.Name] = true
+	}
+}
+
+func setSecretExpireTimeMetric(cu uploadObject, t time.Time) {
+	key := uploadMetricsKey(cu)
+	secretExpireTime.WithLabelValues(key.Kind, key.Namespace, key.Name).Set(float64(t.Unix()))
+}
+
+// deleteTargetExpireTimeMetric deletes the metric of a target removed from a
+// resource.
+func deleteTargetExpireTimeMetric(cu uploadObject, target string) {
+	key := uploadMetricsKey(cu)
+
+	expireTimeTargetsMu.Lock()
+	defer expireTimeTargetsMu.Unlock()
+
+	certificateExpireTime.DeleteLabelValues(key.Kind, key.Namespace, key.Name, target)
+	delete(expireTimeTargets[key], target)
+}
+
+// deleteExpireTimeMetrics deletes metrics of a deleted resource.
+func deleteExpireTimeMetrics(key metricsKey) {
+	expireTimeTargetsMu.Lock()
+	defer expireTimeTargetsMu.Unlock()
+
+	for target := range expireTimeTargets[key] {
+		certificateExpireTime.DeleteLabelValues(key.Kind, key.Namespace, key.Name, target)
+	}
+
+	delete(expireTimeTargets, key)
+	secretExpireTime.DeleteLabelValues(key.Kind, key.Namespace, key.Name)
+}
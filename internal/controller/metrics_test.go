@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpireTimeMetrics(t *testing.T) {
+	expireTime := metav1.Now()
+	status := v1alpha1.CertificateUploadStatus{
+		Targets: []v1alpha1.UploadTargetStatus{
+			{Name: "a", ExpireTime: &expireTime},
+			{Name: "b", ExpireTime: &expireTime},
+		},
+	}
+	cu := &v1alpha1.CertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-test"},
+		Status:     status,
+	}
+	ccu := &v1alpha1.ClusterCertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-test"},
+		Status:     status,
+	}
+
+	count := func() int {
+		return testutil.CollectAndCount(certificateExpireTime)
+	}
+
+	before := count()
+
+	// Resources of different kinds and targets don't overwrite each other
+	updateExpireTimeMetrics(cu)
+	updateExpireTimeMetrics(ccu)
+
+	if actual := count() - before; actual != 4 {
+		t.Fatalf("expected 4 new metrics, got %d", actual)
+	}
+
+	r := newTestReconciler(new(fake.Uploader))
+
+	if _, err := r.removeTargets(context.Background(), cu, []v1alpha1.UploadTarget{{Name: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := count() - before; actual != 3 {
+		t.Fatalf("expected 3 new metrics after pruning, got %d", actual)
+	}
+
+	deleteExpireTimeMetrics(uploadMetricsKey(cu))
+	deleteExpireTimeMetrics(uploadMetricsKey(ccu))
+
+	if actual := count(); actual != before {
+		t.Fatalf("expected %d metrics after deleting, got %d", before, actual)
+	}
+}
+
+func TestSuspendedExpireTimeMetrics(t *testing.T) {
+	expireTime := metav1.Now()
+	cu := &v1alpha1.CertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "suspended-metrics-test"},
+		Spec:       v1alpha1.CertificateUploadSpec{Suspend: true},
+		Status: v1alpha1.CertificateUploadStatus{
+			Targets: []v1alpha1.UploadTargetStatus{{Name: "a", ExpireTime: &expireTime}},
+		},
+	}
+
+	defer deleteExpireTimeMetrics(uploadMetricsKey(cu))
+
+	r := newTestReconciler(new(fake.Uploader))
+
+	if _, err := r.upload(context.Background(), cu); err != nil {
+		t.Fatal(err)
+	}
+
+	gauge := certificateExpireTime.WithLabelValues(v1alpha1.CertificateUploadKind, cu.Namespace, cu.Name, "a")
+
+	if actual := testutil.ToFloat64(gauge); actual != float64(expireTime.Unix()) {
+		t.Fatalf("expected %d, got %v", expireTime.Unix(), actual)
+	}
+}
@@ -0,0 +1,368 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	providerRefField = "spec.targets.providerRef"
+
+	// credentialsSecretField indexes Providers and ClusterProviders by the
+	// namespaced names of their credential secrets.
+	credentialsSecretField = "spec.credentialsSecretKey"
+)
+
+var errProviderMismatch = errors.New("target doesn't match the provider")
+
+// providerKey returns a key identifying the provider which a reference in the
+// namespace refers to.
+func providerKey(namespace string, ref *v1alpha1.ProviderReference) string {
+	if ref.Kind == v1alpha1.ClusterProviderKind {
+		return v1alpha1.ClusterProviderKind + "/" + ref.Name
+	}
+
+	return v1alpha1.ProviderKind + "/" + namespace + "/" + ref.Name
+}
+
+func (r *CertificateUploadReconciler) indexProviderRefs(object client.Object) []string {
+	cu := object.(uploadObject)
+	namespace := r.credentialsNamespace(cu)
+
+	var keys []string
+
+	for _, t := range cu.GetUploadSpec().Targets {
+		if t.ProviderRef != nil {
+			keys = append(keys, providerKey(namespace, t.ProviderRef))
+		}
+	}
+
+	return keys
+}
+
+// credentialsNamespace returns the namespace where secrets and Providers
+// referenced by targets are read from. Cluster-scoped resources read them from
+// the cluster resource namespace.
+func (r *CertificateUploadReconciler) credentialsNamespace(cu uploadObject) string {
+	if ns := cu.GetNamespace(); ns != "" {
+		return ns
+	}
+
+	return r.ClusterResourceNamespace
+}
+
+// newRequest returns a request of the target. Settings of the provider
+// referenced by the target are merged into the target.
+func (r *CertificateUploadReconciler) newRequest(ctx context.Context, cu uploadObject, target *v1alpha1.UploadTarget) (*uploader.Request, error) {
+	req := &uploader.Request{
+		Namespace: r.credentialsNamespace(cu),
+		Target:    target,
+		Owner:     uploadOwner(cu, target),
+	}
+
+	ref := target.ProviderRef
+
+	if ref == nil {
+		return req, nil
+	}
+
+	spec, namespace, err := r.getProvider(ctx, req.Namespace, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	req.CacheKey = providerKey(req.Namespace, ref)
+	req.Namespace = namespace
+	req.Target, err = mergeProvider(target, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// uploadOwner returns the owner of certificates uploaded for the target.
+func uploadOwner(cu uploadObject, target *v1alpha1.UploadTarget) string {
+	return fmt.Sprintf("%s/%s/%s/%s", uploadKind(cu), cu.GetNamespace(), cu.GetName(), target.Name)
+}
+
+// getProvider returns the spec of the provider and the namespace where its
+// secrets are read from.
+func (r *CertificateUploadReconciler) getProvider(ctx context.Context, namespace string, ref *v1alpha1.ProviderReference) (*v1alpha1.ProviderSpec, string, error) {
+	if ref.Kind == v1alpha1.ClusterProviderKind {
+		provider := new(v1alpha1.ClusterProvider)
+
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: ref.Name}, provider); err != nil {
+			return nil, "", fmt.Errorf("failed to get cluster provider %q: %w", ref.Name, err)
+		}
+
+		return &provider.Spec, r.ClusterResourceNamespace, nil
+	}
+
+	provider := new(v1alpha1.Provider)
+	key := types.NamespacedName{
+		Namespace: namespace,
+		Name:      ref.Name,
+	}
+
+	if err := r.Client.Get(ctx, key, provider); err != nil {
+		return nil, "", fmt.Errorf("failed to get provider %q: %w", key, err)
+	}
+
+	return &provider.Spec, namespace, nil
+}
+
+// mergeProvider returns a target with settings of the provider overridden by
+// the target. Credentials and endpoints are always taken from the provider,
+// because secrets of the provider may not be readable by the owner of the
+// target. An error is returned if the target has settings of another kind of
+// provider.
+func mergeProvider(target *v1alpha1.UploadTarget, spec *v1alpha1.ProviderSpec) (*v1alpha1.UploadTarget, error) {
+	switch {
+	case target.Cloudflare != nil && spec.Cloudflare == nil:
+		return nil, fmt.Errorf("%w: provider has no cloudflare settings", errProviderMismatch)
+	case target.ACM != nil && spec.ACM == nil:
+		return nil, fmt.Errorf("%w: provider has no acm settings", errProviderMismatch)
+	case target.GCP != nil && spec.GCP == nil:
+		return nil, fmt.Errorf("%w: provider has no gcp settings", errProviderMismatch)
+	}
+
+	merged := &v1alpha1.UploadTarget{
+		Name:        target.Name,
+		ProviderRef: target.ProviderRef,
+	}
+
+	if spec.Cloudflare != nil {
+		merged.Cloudflare = mergeCloudflare(spec.Cloudflare, target.Cloudflare)
+	}
+
+	if spec.ACM != nil {
+		merged.ACM = mergeACM(spec.ACM, target.ACM)
+	}
+
+	if spec.GCP != nil {
+		merged.GCP = mergeGCP(spec.GCP, target.GCP)
+	}
+
+	return merged, nil
+}
+
+func mergeCloudflare(provider, target *v1alpha1.CloudflareUploadSpec) *v1alpha1.CloudflareUploadSpec {
+	spec := provider.DeepCopy()
+
+	if target == nil {
+		return spec
+	}
+
+	if target.ZoneID != "" || target.ZoneName != "" {
+		spec.ZoneID = target.ZoneID
+		spec.ZoneName = target.ZoneName
+	}
+
+	if target.BundleMethod != "" {
+		spec.BundleMethod = target.BundleMethod
+	}
+
+	if target.Type != "" {
+		spec.Type = target.Type
+	}
+
+	if target.GeoRestrictions != nil {
+		spec.GeoRestrictions = target.GeoRestrictions.DeepCopy()
+	}
+
+	return spec
+}
+
+func mergeACM(provider, target *v1alpha1.ACMUploadSpec) *v1alpha1.ACMUploadSpec {
+	spec := provider.DeepCopy()
+
+	if target == nil {
+		return spec
+	}
+
+	if target.Region != "" {
+		spec.Region = target.Region
+	}
+
+	if target.CertificateARN != "" {
+		spec.CertificateARN = target.CertificateARN
+	}
+
+	if len(target.Tags) > 0 && spec.Tags == nil {
+		spec.Tags = map[string]string{}
+	}
+
+	for k, v := range target.Tags {
+		spec.Tags[k] = v
+	}
+
+	return spec
+}
+
+func mergeGCP(provider, target *v1alpha1.GCPUploadSpec) *v1alpha1.GCPUploadSpec {
+	spec := provider.DeepCopy()
+
+	if target == nil {
+		return spec
+	}
+
+	if target.Project != "" {
+		spec.Project = target.Project
+	}
+
+	if target.Type != "" {
+		spec.Type = target.Type
+	}
+
+	if target.Location != "" {
+		spec.Location = target.Location
+	}
+
+	if target.Name != "" {
+		spec.Name = target.Name
+	}
+
+	if target.Description != "" {
+		spec.Description = target.Description
+	}
+
+	if len(target.Labels) > 0 && spec.Labels == nil {
+		spec.Labels = map[string]string{}
+	}
+
+	for k, v := range target.Labels {
+		spec.Labels[k] = v
+	}
+
+	return spec
+}
+
+// mapProvider returns requests of CertificateUploads referring to a Provider
+// or a ClusterProvider.
+func (r *CertificateUploadReconciler) mapProvider(object client.Object) []reconcile.Request {
+	return r.providerRequests(object, new(v1alpha1.CertificateUploadList))
+}
+
+// providerRequests returns requests of resources in the list type referring to
+// a Provider or a ClusterProvider.
+func (r *CertificateUploadReconciler) providerRequests(object client.Object, list client.ObjectList) []reconcile.Request {
+	var key string
+
+	switch object.(type) {
+	case *v1alpha1.ClusterProvider:
+		key = providerKey("", &v1alpha1.ProviderReference{Kind: v1alpha1.ClusterProviderKind, Name: object.GetName()})
+	default:
+		key = providerKey(object.GetNamespace(), &v1alpha1.ProviderReference{Kind: v1alpha1.ProviderKind, Name: object.GetName()})
+	}
+
+	if err := r.Client.List(context.Background(), list, client.MatchingFields(map[string]string{providerRefField: key})); err != nil {
+		log.Log.Error(err, "Failed to list resources referring to the provider", "provider", key)
+
+		return nil
+	}
+
+	return listRequests(list)
+}
+
+// indexCredentialsSecrets returns keys of secrets storing credentials of a
+// Provider or a ClusterProvider.
+func (r *CertificateUploadReconciler) indexCredentialsSecrets(object client.Object) []string {
+	var (
+		spec      *v1alpha1.ProviderSpec
+		namespace string
+	)
+
+	switch p := object.(type) {
+	case *v1alpha1.ClusterProvider:
+		spec, namespace = &p.Spec, r.ClusterResourceNamespace
+	case *v1alpha1.Provider:
+		spec, namespace = &p.Spec, p.Namespace
+	default:
+		return nil
+	}
+
+	var refs []*corev1.SecretKeySelector
+
+	if c := spec.Cloudflare; c != nil {
+		refs = append(refs, c.APIKeySecretRef, c.APITokenSecretRef)
+	}
+
+	if a := spec.ACM; a != nil {
+		refs = append(refs, a.AccessKeyIDSecretRef, a.SecretAccessKeySecretRef)
+	}
+
+	if g := spec.GCP; g != nil {
+		refs = append(refs, g.ServiceAccountKeySecretRef)
+	}
+
+	var keys []string
+
+	for _, ref := range refs {
+		if ref != nil {
+			keys = append(keys, types.NamespacedName{Namespace: namespace, Name: ref.Name}.String())
+		}
+	}
+
+	return keys
+}
+
+// credentialsRequests returns requests of resources in the list type referring
+// to Providers or ClusterProviders whose credentials are stored in a secret.
+func (r *CertificateUploadReconciler) credentialsRequests(object client.Object, list client.ObjectList) []reconcile.Request {
+	key := client.ObjectKeyFromObject(object).String()
+	opts := client.MatchingFields(map[string]string{credentialsSecretField: key})
+
+	var requests []reconcile.Request
+
+	for _, providers := range []client.ObjectList{new(v1alpha1.ProviderList), new(v1alpha1.ClusterProviderList)} {
+		if err := r.Client.List(context.Background(), providers, opts); err != nil {
+			log.Log.Error(err, "Failed to list providers referring to the secret", "secret", key)
+
+			continue
+		}
+
+		items, err := meta.ExtractList(providers)
+		if err != nil {
+			continue
+		}
+
+		for _, item := range items {
+			if provider, ok := item.(client.Object); ok {
+				requests = append(requests, r.providerRequests(provider, list.DeepCopyObject().(client.ObjectList))...)
+			}
+		}
+	}
+
+	return requests
+}
+
+// listRequests returns requests of all items in a list.
+func listRequests(list client.ObjectList) []reconcile.Request {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(items))
+
+	for _, item := range items {
+		if object, ok := item.(client.Object); ok {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(object),
+			})
+		}
+	}
+
+	return requests
+}
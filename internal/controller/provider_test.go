@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMergeProvider(t *testing.T) {
+	ref := &v1alpha1.ProviderReference{Kind: v1alpha1.ProviderKind, Name: "provider"}
+	secretRef := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"},
+		Key:                  "key",
+	}
+
+	tests := []struct {
+		name     string
+		target   *v1alpha1.UploadTarget
+		spec     *v1alpha1.ProviderSpec
+		expected *v1alpha1.UploadTarget
+		mismatch bool
+	}{
+		{
+			name:   "cloudflare defaults",
+			target: &v1alpha1.UploadTarget{Name: "foo", ProviderRef: ref},
+			spec: &v1alpha1.ProviderSpec{
+				Cloudflare: &v1alpha1.CloudflareUploadSpec{
+					ZoneName:          "example.com",
+					APITokenSecretRef: secretRef,
+					BundleMethod:      v1alpha1.CloudflareBundleMethodUbiquitous,
+				},
+			},
+			expected: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				Cloudflare: &v1alpha1.CloudflareUploadSpec{
+					ZoneName:          "example.com",
+					APITokenSecretRef: secretRef,
+					BundleMethod:      v1alpha1.CloudflareBundleMethodUbiquitous,
+				},
+			},
+		},
+		{
+			name: "cloudflare overrides",
+			target: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				Cloudflare: &v1alpha1.CloudflareUploadSpec{
+					ZoneID:       "zone",
+					BundleMethod: v1alpha1.CloudflareBundleMethodForce,
+				},
+			},
+			spec: &v1alpha1.ProviderSpec{
+				Cloudflare: &v1alpha1.CloudflareUploadSpec{
+					ZoneName:          "example.com",
+					APITokenSecretRef: secretRef,
+					BundleMethod:      v1alpha1.CloudflareBundleMethodUbiquitous,
+					Type:              v1alpha1.CloudflareTypeSNICustom,
+				},
+			},
+			expected: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				Cloudflare: &v1alpha1.CloudflareUploadSpec{
+					ZoneID:            "zone",
+					APITokenSecretRef: secretRef,
+					BundleMethod:      v1alpha1.CloudflareBundleMethodForce,
+					Type:              v1alpha1.CloudflareTypeSNICustom,
+				},
+			},
+		},
+		{
+			name: "acm tags are merged",
+			target: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				ACM: &v1alpha1.ACMUploadSpec{
+					Region: "us-west-2",
+					Tags:   map[string]string{"b": "target", "c": "target"},
+				},
+			},
+			spec: &v1alpha1.ProviderSpec{
+				ACM: &v1alpha1.ACMUploadSpec{
+					Region:               "us-east-1",
+					Endpoint:             "http://localhost",
+					AccessKeyIDSecretRef: secretRef,
+					Tags:                 map[string]string{"a": "provider", "b": "provider"},
+				},
+			},
+			expected: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				ACM: &v1alpha1.ACMUploadSpec{
+					Region:               "us-west-2",
+					Endpoint:             "http://localhost",
+					AccessKeyIDSecretRef: secretRef,
+					Tags:                 map[string]string{"a": "provider", "b": "target", "c": "target"},
+				},
+			},
+		},
+		{
+			name: "gcp overrides",
+			target: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				GCP: &v1alpha1.GCPUploadSpec{
+					Name:   "api",
+					Labels: map[string]string{"app": "api"},
+				},
+			},
+			spec: &v1alpha1.ProviderSpec{
+				GCP: &v1alpha1.GCPUploadSpec{
+					Project:                    "project",
+					Type:                       v1alpha1.GCPTypeCompute,
+					ServiceAccountKeySecretRef: secretRef,
+				},
+			},
+			expected: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				GCP: &v1alpha1.GCPUploadSpec{
+					Project:                    "project",
+					Type:                       v1alpha1.GCPTypeCompute,
+					ServiceAccountKeySecretRef: secretRef,
+					Name:                       "api",
+					Labels:                     map[string]string{"app": "api"},
+				},
+			},
+		},
+		{
+			name: "kind mismatch",
+			target: &v1alpha1.UploadTarget{
+				Name:        "foo",
+				ProviderRef: ref,
+				Cloudflare:  &v1alpha1.CloudflareUploadSpec{ZoneID: "zone"},
+			},
+			spec: &v1alpha1.ProviderSpec{
+				ACM: &v1alpha1.ACMUploadSpec{Region: "us-east-1"},
+			},
+			mismatch: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec.DeepCopy()
+			actual, err := mergeProvider(test.target, spec)
+
+			if test.mismatch {
+				if !errors.Is(err, errProviderMismatch) {
+					t.Fatalf("expected errProviderMismatch, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, actual)
+			}
+
+			// The provider must not be modified
+			if !reflect.DeepEqual(spec, test.spec) {
+				t.Fatalf("provider is modified: %+v", spec)
+			}
+		})
+	}
+}
+
+func TestIndexCredentialsSecrets(t *testing.T) {
+	r := &CertificateUploadReconciler{ClusterResourceNamespace: "cert-uploader"}
+	secretRef := func(name string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  "key",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		object   client.Object
+		expected []string
+	}{
+		{
+			name: "provider",
+			object: &v1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "aws"},
+				Spec: v1alpha1.ProviderSpec{
+					ACM: &v1alpha1.ACMUploadSpec{
+						AccessKeyIDSecretRef:     secretRef("id"),
+						SecretAccessKeySecretRef: secretRef("secret"),
+					},
+				},
+			},
+			expected: []string{"default/id", "default/secret"},
+		},
+		{
+			name: "cluster provider",
+			object: &v1alpha1.ClusterProvider{
+				ObjectMeta: metav1.ObjectMeta{Name: "cloudflare"},
+				Spec: v1alpha1.ProviderSpec{
+					Cloudflare: &v1alpha1.CloudflareUploadSpec{APITokenSecretRef: secretRef("token")},
+				},
+			},
+			expected: []string{"cert-uploader/token"},
+		},
+		{
+			name: "no credentials",
+			object: &v1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "gcp"},
+				Spec:       v1alpha1.ProviderSpec{GCP: &v1alpha1.GCPUploadSpec{Project: "project"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if actual := r.indexCredentialsSecrets(test.object); !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUploadOwner(t *testing.T) {
+	target := &v1alpha1.UploadTarget{Name: "gcp"}
+	owners := []string{
+		uploadOwner(&v1alpha1.CertificateUpload{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"}}, target),
+		uploadOwner(&v1alpha1.CertificateUpload{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "foo"}}, target),
+		uploadOwner(&v1alpha1.ClusterCertificateUpload{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, target),
+		uploadOwner(&v1alpha1.CertificateUpload{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"}}, &v1alpha1.UploadTarget{Name: "other"}),
+	}
+
+	// Owners of different resources and targets don't collide
+	seen := map[string]bool{}
+
+	for _, owner := range owners {
+		if seen[owner] {
+			t.Fatalf("duplicate owner %q", owner)
+		}
+
+		seen[owner] = true
+	}
+
+	if expected := "CertificateUpload/default/foo/gcp"; owners[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, owners[0])
+	}
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultRolloverGracePeriod       = 5 * time.Minute
+	defaultRolloverActivationTimeout = 10 * time.Minute
+
+	// rolloverPollInterval is how often the state of a new certificate is
+	// checked during a rollover.
+	rolloverPollInterval = 30 * time.Second
+)
+
+// rolloverStrategy returns the rollover settings, or nil if certificates are
+// updated in place.
+func rolloverStrategy(spec *v1alpha1.CertificateUploadSpec) *v1alpha1.RolloverUpdateStrategy {
+	if s := spec.UpdateStrategy; s != nil && s.Type == v1alpha1.UpdateStrategyRollover {
+		if s.Rollover == nil {
+			return new(v1alpha1.RolloverUpdateStrategy)
+		}
+
+		return s.Rollover
+	}
+
+	return nil
+}
+
+func rolloverGracePeriod(s *v1alpha1.RolloverUpdateStrategy) time.Duration {
+	if s != nil && s.GracePeriod != nil {
+		return s.GracePeriod.Duration
+	}
+
+	return defaultRolloverGracePeriod
+}
+
+func rolloverActivationTimeout(s *v1alpha1.RolloverUpdateStrategy) time.Duration {
+	if s != nil && s.ActivationTimeout != nil {
+		return s.ActivationTimeout.Duration
+	}
+
+	return defaultRolloverActivationTimeout
+}
+
+// startRollover records the current certificate as the previous one. It must
+// be called before the status is updated with the new certificate.
+func startRollover(status *v1alpha1.UploadTargetStatus, now time.Time) {
+	status.PreviousCertificateID = status.CertificateID
+	status.PreviousZoneID = status.ZoneID
+	status.PreviousZoneName = status.ZoneName
+	status.RolloverTime = timePtr(metav1.NewTime(now))
+	status.PreviousDeleteTime = nil
+}
+
+func clearRollover(status *v1alpha1.UploadTargetStatus) {
+	status.PreviousCertificateID = ""
+	status.PreviousZoneID = ""
+	status.PreviousZoneName = ""
+	status.RolloverTime = nil
+	status.PreviousDeleteTime = nil
+}
+
+// continueRollover checks whether the new certificate of a rollover is active.
+// The previous certificate is deleted after the grace period when the new one
+// is active, or restored when the new one fails to become active. It returns
+// when the rollover should be checked again.
+func (r *CertificateUploadReconciler) continueRollover(ctx context.Context, cu uploadObject, target *v1alpha1.UploadTarget, status *v1alpha1.UploadTargetStatus) (time.Duration, *uploadFailure, error) {
+	req, err := r.newRequest(ctx, cu, target)
+	if err != nil {
+		failure, err := r.providerFailed(cu, target, status, err)
+
+		return 0, failure, err
+	}
+
+	req.Status = status
+	name, u, err := r.Uploaders.Find(req.Target)
+
+	if err != nil {
+		return 0, targetFailed(status, &uploadFailure{
+			Reason:  ReasonMultipleProviders,
+			Message: fmt.Sprintf("Target %q is invalid: %v", target.Name, err),
+		}), nil
+	}
+
+	if u == nil || name != status.Provider || !r.Uploaders.Enabled(name) {
+		return 0, nil, nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("target", target.Name, "provider", u.Name())
+	strategy := rolloverStrategy(cu.GetUploadSpec())
+	now := time.Now()
+
+	if t := status.PreviousDeleteTime; t != nil {
+		if now.Before(t.Time) {
+			return t.Sub(now), nil, nil
+		}
+
+		prevID := status.PreviousCertificateID
+
+		if err := r.deletePreviousCertificate(ctx, req, u, status); err != nil {
+			failure, err := r.uploadFailed(ctx, cu, target, status, u, "delete previous certificate", err)
+
+			return 0, failure, err
+		}
+
+		logger.Info("Previous certificate is deleted", "certificateId", status.CertificateID, "previousCertificateId", prevID)
+		r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonRolledOver, "Deleted previous certificate %q from %s for target %q", prevID, u.Name(), target.Name)
+
+		return 0, nil, nil
+	}
+
+	var state uploader.CertificateState
+
+	remote, err := u.Describe(ctx, req, status.CertificateID)
+
+	switch {
+	case err == nil:
+		state = remote.State
+	case errors.Is(err, uploader.ErrNotFound):
+		state = uploader.CertificateStateFailed
+	default:
+		failure, err := r.uploadFailed(ctx, cu, target, status, u, "get certificate", err)
+
+		return 0, failure, err
+	}
+
+	switch {
+	// Certificates without states are active once uploaded
+	case state == uploader.CertificateStateActive || state == "":
+		gracePeriod := rolloverGracePeriod(strategy)
+		status.PreviousDeleteTime = timePtr(metav1.NewTime(now.Add(gracePeriod)))
+		r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonActivated, "Certificate %q is active on %s for target %q, previous certificate %q will be deleted after %v", status.CertificateID, u.Name(), target.Name, status.PreviousCertificateID, gracePeriod)
+
+		return gracePeriod, nil, nil
+
+	case state == uploader.CertificateStatePending && status.RolloverTime != nil && now.Before(status.RolloverTime.Add(rolloverActivationTimeout(strategy))):
+		logger.V(1).Info("Waiting for certificate to become active", "certificateId", status.CertificateID)
+
+		return rolloverPollInterval, nil, nil
+	}
+
+	failedID := status.CertificateID
+	message := fmt.Sprintf("Certificate %q on %s for target %q failed to become active (state: %s), rolled back to certificate %q", failedID, u.Name(), target.Name, state, status.PreviousCertificateID)
+
+	if err := r.rollback(ctx, req, u, status); err != nil {
+		failure, err := r.uploadFailed(ctx, cu, target, status, u, "roll back certificate", err)
+
+		return 0, failure, err
+	}
+
+	logger.Info("Rollover is rolled back", "certificateId", failedID, "state", state)
+	r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonRolledBack, message)
+
+	// Upload the secret again only when it is changed or the upload is
+	// forced, and wait before retrying in case the secret is changed soon.
+	status.RolledBackFingerprint = status.SecretFingerprint
+	failure := targetFailed(status, &uploadFailure{
+		Reason:  ReasonRolledBack,
+		Message: message,
+	})
+	status.NextRetryTime = timePtr(metav1.NewTime(now.Add(retryDelay(status.FailedAttempts, 0))))
+
+	return 0, failure, nil
+}
+
+// abortRollover ends a rollover in progress before another one is started.
+// The previous certificate is deleted if the new one is active, otherwise the
+// new one is deleted.
+func (r *CertificateUploadReconciler) abortRollover(ctx context.Context, req *uploader.Request, u uploader.Uploader, status *v1alpha1.UploadTargetStatus) error {
+	if status.PreviousDeleteTime != nil {
+		return r.deletePreviousCertificate(ctx, req, u, status)
+	}
+
+	return r.rollback(ctx, req, u, status)
+}
+
+// deletePreviousCertificate deletes the certificate replaced by a rollover.
+func (r *CertificateUploadReconciler) deletePreviousCertificate(ctx context.Context, req *uploader.Request, u uploader.Uploader, status *v1alpha1.UploadTargetStatus) error {
+	prev := status.DeepCopy()
+	prev.CertificateID = status.PreviousCertificateID
+	prev.ZoneID = status.PreviousZoneID
+	prev.ZoneName = status.PreviousZoneName
+
+	prevReq := *req
+	prevReq.Status = prev
+
+	if err := u.Delete(ctx, &prevReq, prev.CertificateID); err != nil && !errors.Is(err, uploader.ErrNotManaged) {
+		return err
+	}
+
+	clearRollover(status)
+
+	return nil
+}
+
+// rollback deletes the new certificate of a rollover and restores the
+// previous one.
+func (r *CertificateUploadReconciler) rollback(ctx context.Context, req *uploader.Request, u uploader.Uploader, status *v1alpha1.UploadTargetStatus) error {
+	if err := u.Delete(ctx, req, status.CertificateID); err != nil && !errors.Is(err, uploader.ErrNotManaged) {
+		return err
+	}
+
+	status.CertificateID = status.PreviousCertificateID
+	status.ZoneID = status.PreviousZoneID
+	status.ZoneName = status.PreviousZoneName
+	clearRollover(status)
+
+	return nil
+}
@@ -0,0 +1,398 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRolloverUpload() *v1alpha1.CertificateUpload {
+	return &v1alpha1.CertificateUpload{
+		Spec: v1alpha1.CertificateUploadSpec{
+			UpdateStrategy: &v1alpha1.UpdateStrategy{
+				Type: v1alpha1.UpdateStrategyRollover,
+				Rollover: &v1alpha1.RolloverUpdateStrategy{
+					GracePeriod: &metav1.Duration{Duration: time.Minute},
+				},
+			},
+		},
+	}
+}
+
+// newRolloverUploader returns an uploader with the previous certificate
+// "cert-1" and the new certificate "cert-2" of a rollover, and the status of
+// the rollover started at the time.
+func newRolloverUploader(t *testing.T, rolloverTime time.Time) (*fake.Uploader, *v1alpha1.UploadTargetStatus) {
+	t.Helper()
+
+	u := new(fake.Uploader)
+
+	for i := 0; i < 2; i++ {
+		if _, err := u.Create(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status := &v1alpha1.UploadTargetStatus{
+		Name:                  "foo",
+		Provider:              "fake",
+		CertificateID:         "cert-2",
+		PreviousCertificateID: "cert-1",
+		RolloverTime:          timePtr(metav1.NewTime(rolloverTime)),
+		SecretFingerprint:     "fingerprint",
+	}
+
+	return u, status
+}
+
+// rolloverCalls returns calls after the certificates are created by
+// newRolloverUploader.
+func rolloverCalls(u *fake.Uploader) []string {
+	return u.Calls()[2:]
+}
+
+// expectRolloverCertificates checks which certificates of the rollover exist.
+func expectRolloverCertificates(t *testing.T, u *fake.Uploader, expected []string) {
+	t.Helper()
+
+	for _, id := range []string{"cert-1", "cert-2"} {
+		exists := u.Certificate(id) != nil
+		want := false
+
+		for _, e := range expected {
+			want = want || e == id
+		}
+
+		if exists != want {
+			t.Errorf("expected certificate %s to exist: %v, got %v", id, want, exists)
+		}
+	}
+}
+
+func TestContinueRollover(t *testing.T) {
+	ctx := context.Background()
+	target := &v1alpha1.UploadTarget{Name: "foo"}
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		state        uploader.CertificateState
+		deleted      bool
+		rolloverTime time.Time
+		deleteTime   *time.Time
+		err          error
+
+		delay           time.Duration
+		reason          string
+		calls           []string
+		certificateID   string
+		previousID      string
+		deleteScheduled bool
+		certificates    []string
+	}{
+		{
+			name:            "active",
+			state:           uploader.CertificateStateActive,
+			rolloverTime:    now,
+			delay:           time.Minute,
+			calls:           []string{"Describe cert-2"},
+			certificateID:   "cert-2",
+			previousID:      "cert-1",
+			deleteScheduled: true,
+			certificates:    []string{"cert-1", "cert-2"},
+		},
+		{
+			name:            "without state",
+			rolloverTime:    now,
+			delay:           time.Minute,
+			calls:           []string{"Describe cert-2"},
+			certificateID:   "cert-2",
+			previousID:      "cert-1",
+			deleteScheduled: true,
+			certificates:    []string{"cert-1", "cert-2"},
+		},
+		{
+			name:          "pending",
+			state:         uploader.CertificateStatePending,
+			rolloverTime:  now,
+			delay:         rolloverPollInterval,
+			calls:         []string{"Describe cert-2"},
+			certificateID: "cert-2",
+			previousID:    "cert-1",
+			certificates:  []string{"cert-1", "cert-2"},
+		},
+		{
+			name:          "activation timeout",
+			state:         uploader.CertificateStatePending,
+			rolloverTime:  now.Add(-defaultRolloverActivationTimeout - time.Second),
+			reason:        ReasonRolledBack,
+			calls:         []string{"Describe cert-2", "Delete cert-2"},
+			certificateID: "cert-1",
+			certificates:  []string{"cert-1"},
+		},
+		{
+			name:          "failed",
+			state:         uploader.CertificateStateFailed,
+			rolloverTime:  now,
+			reason:        ReasonRolledBack,
+			calls:         []string{"Describe cert-2", "Delete cert-2"},
+			certificateID: "cert-1",
+			certificates:  []string{"cert-1"},
+		},
+		{
+			name:          "not found",
+			deleted:       true,
+			rolloverTime:  now,
+			reason:        ReasonRolledBack,
+			calls:         []string{"Describe cert-2", "Delete cert-2"},
+			certificateID: "cert-1",
+			certificates:  []string{"cert-1"},
+		},
+		{
+			name:          "describe failure",
+			rolloverTime:  now,
+			err:           errors.New("describe failed"),
+			reason:        ReasonFailed,
+			calls:         []string{"Describe cert-2"},
+			certificateID: "cert-2",
+			previousID:    "cert-1",
+			certificates:  []string{"cert-1", "cert-2"},
+		},
+		{
+			name:            "grace period not expired",
+			rolloverTime:    now.Add(-time.Minute),
+			deleteTime:      timeValuePtr(now.Add(time.Minute)),
+			delay:           time.Minute,
+			certificateID:   "cert-2",
+			previousID:      "cert-1",
+			deleteScheduled: true,
+			certificates:    []string{"cert-1", "cert-2"},
+		},
+		{
+			name:          "grace period expired",
+			rolloverTime:  now.Add(-time.Minute),
+			deleteTime:    timeValuePtr(now.Add(-time.Second)),
+			calls:         []string{"Delete cert-1"},
+			certificateID: "cert-2",
+			certificates:  []string{"cert-2"},
+		},
+		{
+			name:            "previous certificate delete failure",
+			rolloverTime:    now.Add(-time.Minute),
+			deleteTime:      timeValuePtr(now.Add(-time.Second)),
+			err:             &uploader.RetryableError{Err: errors.New("delete failed")},
+			reason:          ReasonRetrying,
+			calls:           []string{"Delete cert-1"},
+			certificateID:   "cert-2",
+			previousID:      "cert-1",
+			deleteScheduled: true,
+			certificates:    []string{"cert-1", "cert-2"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			u, status := newRolloverUploader(t, test.rolloverTime)
+			r := newTestReconciler(u)
+
+			if test.state != "" {
+				u.Put(&uploader.Certificate{ID: "cert-2", State: test.state})
+			}
+
+			if test.deleted {
+				if err := u.Delete(ctx, nil, "cert-2"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.deleteTime != nil {
+				status.PreviousDeleteTime = timePtr(metav1.NewTime(*test.deleteTime))
+			}
+
+			u.Err = test.err
+			before := len(u.Calls())
+
+			delay, failure, err := r.continueRollover(ctx, newRolloverUpload(), target, status)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Delays until the grace period expires are calculated from the
+			// current time
+			if delay > test.delay || delay < test.delay-time.Second {
+				t.Errorf("expected delay %v, got %v", test.delay, delay)
+			}
+
+			switch {
+			case test.reason == "" && failure != nil:
+				t.Errorf("unexpected failure: %+v", failure)
+			case test.reason != "" && (failure == nil || failure.Reason != test.reason):
+				t.Errorf("expected failure reason %s, got %+v", test.reason, failure)
+			}
+
+			if calls := u.Calls()[before:]; !reflect.DeepEqual(calls, test.calls) && (len(calls) != 0 || len(test.calls) != 0) {
+				t.Errorf("expected calls %v, got %v", test.calls, calls)
+			}
+
+			if status.CertificateID != test.certificateID || status.PreviousCertificateID != test.previousID || (status.PreviousDeleteTime != nil) != test.deleteScheduled {
+				t.Errorf("unexpected status: %+v", status)
+			}
+
+			expectRolloverCertificates(t, u, test.certificates)
+		})
+	}
+}
+
+func TestContinueRolloverRetry(t *testing.T) {
+	ctx := context.Background()
+	target := &v1alpha1.UploadTarget{Name: "foo"}
+	u, status := newRolloverUploader(t, time.Now().Add(-time.Minute))
+	r := newTestReconciler(u)
+	status.PreviousDeleteTime = timePtr(metav1.NewTime(time.Now()))
+
+	u.Err = &uploader.RetryableError{Err: errors.New("delete failed")}
+
+	if _, failure, err := r.continueRollover(ctx, newRolloverUpload(), target, status); failure == nil || err != nil {
+		t.Fatalf("expected a failure, got %+v, %v", failure, err)
+	}
+
+	if status.FailedAttempts != 1 || status.NextRetryTime == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The previous certificate is deleted when the rollover is checked again
+	u.Err = nil
+
+	if _, failure, err := r.continueRollover(ctx, newRolloverUpload(), target, status); failure != nil || err != nil {
+		t.Fatalf("unexpected failure: %+v, %v", failure, err)
+	}
+
+	if calls := rolloverCalls(u); !reflect.DeepEqual(calls, []string{"Delete cert-1", "Delete cert-1"}) {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+
+	if status.PreviousCertificateID != "" || status.RolloverTime != nil || u.Certificate("cert-1") != nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestAbortRollover(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		active        bool
+		err           error
+		calls         []string
+		certificateID string
+		previousID    string
+		certificates  []string
+	}{
+		{
+			name:          "new certificate active",
+			active:        true,
+			calls:         []string{"Delete cert-1"},
+			certificateID: "cert-2",
+			certificates:  []string{"cert-2"},
+		},
+		{
+			name:          "new certificate not active",
+			calls:         []string{"Delete cert-2"},
+			certificateID: "cert-1",
+			certificates:  []string{"cert-1"},
+		},
+		{
+			name:          "not managed",
+			err:           uploader.ErrNotManaged,
+			calls:         []string{"Delete cert-2"},
+			certificateID: "cert-1",
+			certificates:  []string{"cert-1", "cert-2"},
+		},
+		{
+			name:          "previous certificate delete failure",
+			active:        true,
+			err:           errors.New("delete failed"),
+			calls:         []string{"Delete cert-1"},
+			certificateID: "cert-2",
+			previousID:    "cert-1",
+			certificates:  []string{"cert-1", "cert-2"},
+		},
+		{
+			name:          "new certificate delete failure",
+			err:           errors.New("delete failed"),
+			calls:         []string{"Delete cert-2"},
+			certificateID: "cert-2",
+			previousID:    "cert-1",
+			certificates:  []string{"cert-1", "cert-2"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			u, status := newRolloverUploader(t, time.Now())
+			r := newTestReconciler(u)
+
+			if test.active {
+				status.PreviousDeleteTime = timePtr(metav1.NewTime(time.Now().Add(time.Minute)))
+			}
+
+			u.Err = test.err
+			err := r.abortRollover(ctx, &uploader.Request{Status: status}, u, status)
+
+			if test.err != nil && !errors.Is(test.err, uploader.ErrNotManaged) {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if calls := rolloverCalls(u); !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("expected calls %v, got %v", test.calls, calls)
+			}
+
+			if status.CertificateID != test.certificateID || status.PreviousCertificateID != test.previousID {
+				t.Errorf("unexpected status: %+v", status)
+			}
+
+			expectRolloverCertificates(t, u, test.certificates)
+		})
+	}
+}
+
+func TestRolloverNewSecret(t *testing.T) {
+	ctx := context.Background()
+	target := &v1alpha1.UploadTarget{Name: "foo"}
+	u, status := newRolloverUploader(t, time.Now())
+	r := newTestReconciler(u)
+	source := newTestSource()
+	source.Fingerprint = "new-fingerprint"
+
+	// The pending rollover is rolled back before another one is started
+	if failure, err := r.uploadTarget(ctx, newRolloverUpload(), source, target, status); failure != nil || err != nil {
+		t.Fatalf("unexpected failure: %+v, %v", failure, err)
+	}
+
+	if calls := rolloverCalls(u); !reflect.DeepEqual(calls, []string{"Delete cert-2", "Describe cert-1", "Create"}) {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+
+	if status.CertificateID != "cert-3" || status.PreviousCertificateID != "cert-1" || status.PreviousDeleteTime != nil || status.RolloverTime == nil || status.SecretFingerprint != "new-fingerprint" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func timeValuePtr(t time.Time) *time.Time {
+	return &t
+}
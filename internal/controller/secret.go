@@ -6,49 +6,100 @@ import (
 
 	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-const secretNameField = "spec.secretName"
+// secretKeyField indexes resources by the namespaced name of their secrets.
+const secretKeyField = "spec.secretKey"
+
+// +kubebuilder:rbac:groups=cert-uploader.dev,resources=certificateuploads,verbs=create;delete
+// +kubebuilder:rbac:groups="",resources=secrets/finalizers,verbs=update
 
 type SecretReconciler struct {
 	Client                      client.Client
+	EventRecorder               record.EventRecorder
 	CertificateUploadReconciler *CertificateUploadReconciler
+
+	// Selector restricts secrets watched by the controller. All secrets are
+	// watched if it is nil.
+	Selector labels.Selector
 }
 
-func (r *SecretReconciler) SetupWithManager(mgr manager.Manager) error {
-	err := mgr.GetFieldIndexer().IndexField(context.TODO(), new(v1alpha1.CertificateUpload), secretNameField, func(object client.Object) []string {
-		return []string{object.(*v1alpha1.CertificateUpload).Spec.SecretName}
-	})
-	if err != nil {
-		return fmt.Errorf("index failed: %w", err)
+func indexSecretKey(object client.Object) []string {
+	key := secretKey(object.(uploadObject))
+
+	if key.Name == "" {
+		return nil
+	}
+
+	return []string{key.String()}
+}
+
+// watchSecrets indexes resources by their secrets, and watches secrets
+// matching the selector.
+func watchSecrets(mgr manager.Manager, b *builder.Builder, object client.Object, mapFn handler.MapFunc, selector labels.Selector) (*builder.Builder, error) {
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), object, secretKeyField, indexSecretKey); err != nil {
+		return nil, fmt.Errorf("index failed: %w", err)
+	}
+
+	return b.Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(mapFn), builder.WithPredicates(selectorPredicate(selector))), nil
+}
+
+// secretRequests returns requests of resources in the list type uploading a
+// secret.
+func (r *CertificateUploadReconciler) secretRequests(object client.Object, list client.ObjectList) []reconcile.Request {
+	key := client.ObjectKeyFromObject(object).String()
+
+	if err := r.Client.List(context.Background(), list, client.MatchingFields(map[string]string{secretKeyField: key})); err != nil {
+		log.Log.Error(err, "Failed to list resources referring to the secret", "secret", key)
+
+		return nil
 	}
 
+	return listRequests(list)
+}
+
+// mapSecret returns requests of CertificateUploads uploading a secret or
+// referring to providers whose credentials are stored in it.
+func (r *CertificateUploadReconciler) mapSecret(object client.Object) []reconcile.Request {
+	return append(
+		r.secretRequests(object, new(v1alpha1.CertificateUploadList)),
+		r.credentialsRequests(object, new(v1alpha1.CertificateUploadList))...,
+	)
+}
+
+func (r *SecretReconciler) SetupWithManager(mgr manager.Manager) error {
 	return builder.
 		ControllerManagedBy(mgr).
-		For(&corev1.Secret{}).
+		For(&corev1.Secret{}, builder.WithPredicates(selectorPredicate(r.Selector))).
+		WithOptions(r.CertificateUploadReconciler.controllerOptions()).
+		// Restore managed CertificateUploads changed by users
+		Owns(&v1alpha1.CertificateUpload{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
 
+// Reconcile creates, updates or deletes the CertificateUpload managed by
+// annotations of a secret. Secrets are uploaded by CertificateUpload and
+// ClusterCertificateUpload controllers, which watch secrets too.
 func (r *SecretReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	list := new(v1alpha1.CertificateUploadList)
-	err := r.Client.List(ctx, list, client.InNamespace(req.Namespace), client.MatchingFields(map[string]string{
-		secretNameField: req.Name,
-	}))
-	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("list failed: %w", err)
+	secret := new(corev1.Secret)
+
+	if err := r.Client.Get(ctx, req.NamespacedName, secret); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
 
-	for _, item := range list.Items {
-		item := item
-		result, err := r.CertificateUploadReconciler.upload(ctx, &item)
-		if err != nil {
-			return result, err
-		}
+	if !matchesSelector(r.Selector, secret) {
+		return reconcile.Result{}, nil
 	}
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{}, r.reconcileManagedUpload(ctx, secret)
 }
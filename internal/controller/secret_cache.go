@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const defaultResyncPeriod = 10 * time.Hour
+
+var errSecretIndexUnsupported = errors.New("secrets in the cache can't be indexed")
+
+// nolint: gochecknoglobals
+var (
+	// TLSSecretSelector selects secrets of type kubernetes.io/tls.
+	TLSSecretSelector = fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS))
+
+	secretGVK = corev1.SchemeGroupVersion.WithKind("Secret")
+)
+
+// SecretCache returns a cache storing only secrets matching the selectors,
+// which cuts memory used by large secrets like Helm releases. Secrets not in
+// the cache are read from the API server, and lists of secrets are always read
+// from the API server. Other resources are stored in the cache created by
+// newCache. Reading a secret outside the namespaces returns
+// ErrNamespaceNotWatched, like NamespacedCache.
+//
+// Secrets not in the cache are not watched either, so uploads aren't
+// reconciled when credentials stored in Opaque secrets are rotated. New
+// credentials are used from the next reconcile.
+func SecretCache(newCache cache.NewCacheFunc, namespaces []string, fieldSelector fields.Selector, labelSelector labels.Selector) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		delegate, err := newCache(config, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		reader, err := client.New(config, client.Options{Scheme: opts.Scheme, Mapper: opts.Mapper})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client: %w", err)
+		}
+
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clientset: %w", err)
+		}
+
+		resync := defaultResyncPeriod
+		if opts.Resync != nil {
+			resync = *opts.Resync
+		}
+
+		var watched map[string]bool
+
+		if len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		} else {
+			watched = make(map[string]bool, len(namespaces))
+
+			for _, ns := range namespaces {
+				watched[ns] = true
+			}
+		}
+
+		tweak := func(options *metav1.ListOptions) {
+			if fieldSelector != nil {
+				options.FieldSelector = fieldSelector.String()
+			}
+
+			if labelSelector != nil {
+				options.LabelSelector = labelSelector.String()
+			}
+		}
+
+		informer := make(secretInformer, len(namespaces))
+
+		for i, ns := range namespaces {
+			informer[i] = coreinformers.NewFilteredSecretInformer(clientset, ns, resync, toolscache.Indexers{
+				toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+			}, tweak)
+		}
+
+		return &secretCache{
+			Cache:      delegate,
+			reader:     reader,
+			informer:   informer,
+			namespaces: watched,
+		}, nil
+	}
+}
+
+type secretCache struct {
+	cache.Cache
+
+	reader   client.Reader
+	informer secretInformer
+
+	// namespaces are the watched namespaces. All namespaces are watched if it
+	// is nil.
+	namespaces map[string]bool
+}
+
+var _ cache.Cache = (*secretCache)(nil)
+
+func (c *secretCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return c.Cache.Get(ctx, key, obj)
+	}
+
+	if c.namespaces != nil && !c.namespaces[key.Namespace] {
+		return fmt.Errorf("%w: failed to get %s", ErrNamespaceNotWatched, key)
+	}
+
+	for _, inf := range c.informer {
+		item, exists, err := inf.GetIndexer().GetByKey(key.String())
+		if err != nil {
+			return fmt.Errorf("failed to get secret from cache: %w", err)
+		}
+
+		if exists {
+			item.(*corev1.Secret).DeepCopyInto(secret)
+			secret.GetObjectKind().SetGroupVersionKind(secretGVK)
+
+			return nil
+		}
+	}
+
+	return c.reader.Get(ctx, key, obj)
+}
+
+func (c *secretCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.SecretList); ok {
+		return c.reader.List(ctx, list, opts...)
+	}
+
+	return c.Cache.List(ctx, list, opts...)
+}
+
+func (c *secretCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return c.informer, nil
+	}
+
+	return c.Cache.GetInformer(ctx, obj)
+}
+
+func (c *secretCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	if gvk == secretGVK {
+		return c.informer, nil
+	}
+
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+func (c *secretCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return errSecretIndexUnsupported
+	}
+
+	return c.Cache.IndexField(ctx, obj, field, extractValue)
+}
+
+func (c *secretCache) Start(ctx context.Context) error {
+	for _, inf := range c.informer {
+		go inf.Run(ctx.Done())
+	}
+
+	return c.Cache.Start(ctx)
+}
+
+func (c *secretCache) WaitForCacheSync(ctx context.Context) bool {
+	if !toolscache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return false
+	}
+
+	return c.Cache.WaitForCacheSync(ctx)
+}
+
+// secretInformer is informers of secrets in multiple namespaces.
+type secretInformer []toolscache.SharedIndexInformer
+
+func (s secretInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	for _, inf := range s {
+		inf.AddEventHandler(handler)
+	}
+}
+
+func (s secretInformer) AddEventHandlerWithResyncPeriod(handler toolscache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, inf := range s {
+		inf.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (s secretInformer) AddIndexers(indexers toolscache.Indexers) error {
+	for _, inf := range s {
+		if err := inf.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s secretInformer) HasSynced() bool {
+	for _, inf := range s {
+		if !inf.HasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
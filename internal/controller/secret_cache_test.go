@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// readerCache is a cache reading objects from a reader.
+type readerCache struct {
+	informertest.FakeInformers
+
+	reader client.Reader
+}
+
+func (c *readerCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.reader.Get(ctx, key, obj)
+}
+
+func (c *readerCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.reader.List(ctx, list, opts...)
+}
+
+// newTestSecretCache returns a secret cache storing the TLS secret
+// "default/tls". The API server stores the TLS secret and the Opaque secret
+// "default/credentials". Other objects are read from the delegated cache,
+// which stores the CertificateUpload "default/foo".
+func newTestSecretCache(t *testing.T) *secretCache {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+
+	delegate := &readerCache{
+		reader: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.CertificateUpload{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+		}).Build(),
+	}
+
+	newCache := func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		return delegate, nil
+	}
+
+	c, err := SecretCache(newCache, []string{"default"}, TLSSecretSelector, nil)(&rest.Config{Host: "http://localhost"}, cache.Options{
+		Scheme: scheme,
+		Mapper: mapper,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := c.(*secretCache)
+	sc.reader = fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte("api server")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "credentials"},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{"token": []byte("api server")},
+		},
+	).Build()
+
+	// The informer isn't started, so the secret is added to its store
+	err = sc.informer[0].GetIndexer().Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cache")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sc
+}
+
+func TestSecretCache(t *testing.T) {
+	ctx := context.Background()
+	c := newTestSecretCache(t)
+
+	t.Run("get cached secret", func(t *testing.T) {
+		secret := new(corev1.Secret)
+
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "tls"}, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(secret.Data[corev1.TLSCertKey]) != "cache" || secret.GroupVersionKind() != secretGVK {
+			t.Fatalf("unexpected secret: %+v", secret)
+		}
+
+		// Secrets are copied from the cache
+		secret.Data[corev1.TLSCertKey] = []byte("changed")
+		cached := new(corev1.Secret)
+
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "tls"}, cached); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(cached.Data[corev1.TLSCertKey]) != "cache" {
+			t.Fatalf("cached secret is changed: %+v", cached)
+		}
+	})
+
+	t.Run("get uncached secret", func(t *testing.T) {
+		secret := new(corev1.Secret)
+
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "credentials"}, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(secret.Data["token"]) != "api server" {
+			t.Fatalf("unexpected secret: %+v", secret)
+		}
+	})
+
+	t.Run("get missing secret", func(t *testing.T) {
+		err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "missing"}, new(corev1.Secret))
+
+		if !kerrors.IsNotFound(err) {
+			t.Fatalf("expected a not found error, got %v", err)
+		}
+	})
+
+	t.Run("get secret from other namespace", func(t *testing.T) {
+		err := c.Get(ctx, types.NamespacedName{Namespace: "other", Name: "credentials"}, new(corev1.Secret))
+
+		if !errors.Is(err, ErrNamespaceNotWatched) {
+			t.Fatalf("expected ErrNamespaceNotWatched, got %v", err)
+		}
+	})
+
+	t.Run("get other object", func(t *testing.T) {
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "foo"}, new(v1alpha1.CertificateUpload)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("list secrets", func(t *testing.T) {
+		list := new(corev1.SecretList)
+
+		if err := c.List(ctx, list); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Items) != 2 {
+			t.Fatalf("expected 2 secrets from the API server, got %d", len(list.Items))
+		}
+	})
+
+	t.Run("list other objects", func(t *testing.T) {
+		list := new(v1alpha1.CertificateUploadList)
+
+		if err := c.List(ctx, list); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Items) != 1 {
+			t.Fatalf("expected 1 CertificateUpload, got %d", len(list.Items))
+		}
+	})
+
+	t.Run("informer", func(t *testing.T) {
+		informer, err := c.GetInformer(ctx, new(corev1.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if inf, ok := informer.(secretInformer); !ok || len(inf) != 1 {
+			t.Fatalf("unexpected informer: %T", informer)
+		}
+
+		informer, err = c.GetInformerForKind(ctx, secretGVK)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := informer.(secretInformer); !ok {
+			t.Fatalf("unexpected informer: %T", informer)
+		}
+	})
+
+	t.Run("index secrets", func(t *testing.T) {
+		err := c.IndexField(ctx, new(corev1.Secret), "type", func(client.Object) []string { return nil })
+
+		if !errors.Is(err, errSecretIndexUnsupported) {
+			t.Fatalf("expected errSecretIndexUnsupported, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// matchesSelector returns true if labels of the object match the selector, or
+// the selector is nil.
+func matchesSelector(selector labels.Selector, object client.Object) bool {
+	return selector == nil || selector.Matches(labels.Set(object.GetLabels()))
+}
+
+// selectorPredicate filters events of objects not matching the selector.
+func selectorPredicate(selector labels.Selector) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return matchesSelector(selector, object)
+	})
+}
@@ -0,0 +1,114 @@
+package controller_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tommy351/cert-uploader/internal/controller"
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/internal/uploader/cloudflaretest"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// nolint: gochecknoglobals
+var (
+	testEnv   *envtest.Environment
+	k8sClient client.Client
+	fakeAPI   *cloudflaretest.Server
+	cancel    context.CancelFunc
+)
+
+func TestController(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run hack/download-test-assets.sh first")
+	}
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controller Suite")
+}
+
+var _ = BeforeSuite(func() {
+	log.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "deployment", "base", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+
+	cfg, err := testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	mgr, err := manager.New(cfg, manager.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	fakeAPI = cloudflaretest.NewServer()
+
+	uploaders := uploader.NewRegistry()
+	uploaders.Register("cloudflare", &uploader.Cloudflare{
+		Client:  mgr.GetClient(),
+		BaseURL: fakeAPI.URL(),
+	})
+
+	cur := &controller.CertificateUploadReconciler{
+		Client:                   mgr.GetClient(),
+		EventRecorder:            mgr.GetEventRecorderFor("cert-uploader"),
+		Uploaders:                uploaders,
+		ClusterResourceNamespace: "default",
+	}
+	Expect(cur.SetupWithManager(mgr)).To(Succeed())
+
+	ccur := &controller.ClusterCertificateUploadReconciler{
+		CertificateUploadReconciler: cur,
+	}
+	Expect(ccur.SetupWithManager(mgr)).To(Succeed())
+
+	sr := &controller.SecretReconciler{
+		Client:                      mgr.GetClient(),
+		EventRecorder:               mgr.GetEventRecorderFor("cert-uploader"),
+		CertificateUploadReconciler: cur,
+	}
+	Expect(sr.SetupWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+}, 60)
+
+var _ = AfterSuite(func() {
+	if cancel != nil {
+		cancel()
+	}
+
+	if fakeAPI != nil {
+		fakeAPI.Close()
+	}
+
+	if testEnv != nil {
+		Expect(testEnv.Stop()).To(Succeed())
+	}
+})
@@ -0,0 +1,574 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	legacyCloudflareTarget = "cloudflare"
+	legacyACMTarget        = "acm"
+)
+
+const (
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// uploadFailure describes an upload which failed. It is retried after
+// RetryAfter if set, otherwise not until the resource or the secret is
+// changed.
+type uploadFailure struct {
+	// Condition is the type of the condition which should be set to false
+	// because of the failure, e.g. CredentialsValid. It is optional.
+	Condition string
+	Reason    string
+	Message   string
+
+	// RetryAfter is positive if the failure is temporary and the upload should
+	// be retried after the duration.
+	RetryAfter time.Duration
+}
+
+// certificateSource is a validated TLS secret to upload.
+type certificateSource struct {
+	Secret      *corev1.Secret
+	Leaf        *x509.Certificate
+	Fingerprint string
+}
+
+// uploadTargets returns all targets of a CertificateUpload, including the
+// deprecated provider fields in spec.
+func uploadTargets(cu uploadObject) []v1alpha1.UploadTarget {
+	var targets []v1alpha1.UploadTarget
+
+	if cu.GetUploadSpec().Cloudflare != nil {
+		targets = append(targets, v1alpha1.UploadTarget{
+			Name:       legacyCloudflareTarget,
+			Cloudflare: cu.GetUploadSpec().Cloudflare,
+		})
+	}
+
+	if cu.GetUploadSpec().ACM != nil {
+		targets = append(targets, v1alpha1.UploadTarget{
+			Name: legacyACMTarget,
+			ACM:  cu.GetUploadSpec().ACM,
+		})
+	}
+
+	return append(targets, cu.GetUploadSpec().Targets...)
+}
+
+func findTargetStatus(cu uploadObject, name string) *v1alpha1.UploadTargetStatus {
+	for i := range cu.GetUploadStatus().Targets {
+		if cu.GetUploadStatus().Targets[i].Name == name {
+			return &cu.GetUploadStatus().Targets[i]
+		}
+	}
+
+	return nil
+}
+
+// targetStatus returns the status of a target. A new status is appended to
+// the CertificateUpload if it does not exist.
+func targetStatus(cu uploadObject, name string) *v1alpha1.UploadTargetStatus {
+	if status := findTargetStatus(cu, name); status != nil {
+		return status
+	}
+
+	status := v1alpha1.UploadTargetStatus{Name: name}
+
+	// Migrate from the deprecated status fields. Legacy targets are named
+	// after their providers.
+	switch {
+	case name == legacyCloudflareTarget && cu.GetUploadStatus().Cloudflare != nil:
+		status.Provider = legacyCloudflareTarget
+		status.CertificateID = cu.GetUploadStatus().Cloudflare.CertificateID
+		status.SecretResourceVersion = cu.GetUploadStatus().SecretResourceVersion
+	case name == legacyACMTarget && cu.GetUploadStatus().ACM != nil:
+		status.Provider = legacyACMTarget
+		status.CertificateID = cu.GetUploadStatus().ACM.CertificateARN
+		status.SecretResourceVersion = cu.GetUploadStatus().SecretResourceVersion
+	}
+
+	cu.GetUploadStatus().Targets = append(cu.GetUploadStatus().Targets, status)
+
+	return &cu.GetUploadStatus().Targets[len(cu.GetUploadStatus().Targets)-1]
+}
+
+func targetNames(targets []v1alpha1.UploadTarget) map[string]bool {
+	names := make(map[string]bool, len(targets))
+
+	for _, t := range targets {
+		names[t.Name] = true
+	}
+
+	return names
+}
+
+// removedTargets returns targets which were removed from spec, but still have
+// statuses containing their last uploaded spec.
+func removedTargets(cu uploadObject, names map[string]bool) []v1alpha1.UploadTarget {
+	var targets []v1alpha1.UploadTarget
+
+	for _, s := range cu.GetUploadStatus().Targets {
+		if names[s.Name] || s.Spec == nil {
+			continue
+		}
+
+		target := s.Spec.DeepCopy()
+		target.Name = s.Name
+		targets = append(targets, *target)
+	}
+
+	return targets
+}
+
+// removeTargets deletes certificates of targets which were removed from spec,
+// unless the deletion policy is Retain, and removes their statuses. Statuses
+// of targets whose certificates failed to be deleted are kept, so the deletion
+// is retried.
+func (r *CertificateUploadReconciler) removeTargets(ctx context.Context, cu uploadObject, targets []v1alpha1.UploadTarget) ([]*uploadFailure, error) {
+	var (
+		names    = targetNames(targets)
+		failed   = map[string]bool{}
+		failures []*uploadFailure
+		retryErr error
+	)
+
+	if cu.GetUploadSpec().DeletionPolicy != v1alpha1.DeletionPolicyRetain {
+		for _, s := range cu.GetUploadStatus().Targets {
+			// Specs of targets are not recorded by older versions
+			if !names[s.Name] && s.Spec == nil && s.CertificateID != "" {
+				r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonDeleteFailed, "Can't delete certificate %q of removed target %q because its spec is unknown", s.CertificateID, s.Name)
+			}
+		}
+
+		removed := removedTargets(cu, names)
+
+		for i := range removed {
+			target := &removed[i]
+
+			if err := r.deleteTarget(ctx, cu, target); err != nil {
+				message := fmt.Sprintf("Failed to delete certificate of removed target %q: %v", target.Name, err)
+				findTargetStatus(cu, target.Name).LastError = message
+				failed[target.Name] = true
+				failures = append(failures, &uploadFailure{
+					Reason:  ReasonDeleteFailed,
+					Message: message,
+				})
+
+				if retryErr == nil {
+					retryErr = err
+				}
+			}
+		}
+	}
+
+	statuses := cu.GetUploadStatus().Targets[:0]
+
+	for _, s := range cu.GetUploadStatus().Targets {
+		if names[s.Name] || failed[s.Name] {
+			statuses = append(statuses, s)
+		} else {
+			deleteTargetExpireTimeMetric(cu, s.Name)
+		}
+	}
+
+	cu.GetUploadStatus().Targets = statuses
+
+	return failures, retryErr
+}
+
+// uploadTarget uploads a certificate to a target. It returns an uploadFailure
+// when the upload failed, or an error when the upload should be retried with
+// the rate limiter of the controller.
+func (r *CertificateUploadReconciler) uploadTarget(ctx context.Context, cu uploadObject, source *certificateSource, target *v1alpha1.UploadTarget, status *v1alpha1.UploadTargetStatus) (failure *uploadFailure, err error) {
+	req, err := r.newRequest(ctx, cu, target)
+	if err != nil {
+		return r.providerFailed(cu, target, status, err)
+	}
+
+	req.Secret = source.Secret
+	req.Status = status
+	name, u, err := r.Uploaders.Find(req.Target)
+
+	if err != nil {
+		message := fmt.Sprintf("Target %q is invalid: %v", target.Name, err)
+		r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonMultipleProviders, message)
+
+		return targetFailed(status, &uploadFailure{
+			Reason:  ReasonMultipleProviders,
+			Message: message,
+		}), nil
+	}
+
+	if u == nil {
+		return targetFailed(status, &uploadFailure{
+			Reason:  ReasonNoProvider,
+			Message: fmt.Sprintf("Target %q has no provider", target.Name),
+		}), nil
+	}
+
+	if !r.Uploaders.Enabled(name) {
+		return targetFailed(status, &uploadFailure{
+			Reason:  ReasonProviderDisabled,
+			Message: fmt.Sprintf("Provider %s of target %q is disabled", u.Name(), target.Name),
+		}), nil
+	}
+
+	start := time.Now()
+	uploadAttempts.WithLabelValues(name).Inc()
+
+	defer func() {
+		uploadDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+		if failure != nil || err != nil {
+			uploadFailures.WithLabelValues(name, failureReason(failure)).Inc()
+		}
+	}()
+
+	// The certificate can't be updated if the provider of the target is changed
+	if status.Provider != name {
+		status.CertificateID = ""
+		status.ZoneID = ""
+		status.ZoneName = ""
+		clearRollover(status)
+	}
+
+	status.Provider = name
+	logger := log.FromContext(ctx).WithValues("target", target.Name, "provider", u.Name())
+
+	if v, ok := u.(uploader.Verifier); ok {
+		if err := v.Verify(ctx, req); err != nil {
+			return r.uploadFailed(ctx, cu, target, status, u, "verify credentials", err)
+		}
+	}
+
+	if v, ok := u.(uploader.Validator); ok {
+		if err := v.Validate(ctx, req, source.Leaf); err != nil {
+			if !errors.Is(err, uploader.ErrHostsNotCovered) {
+				return r.uploadFailed(ctx, cu, target, status, u, "validate certificate", err)
+			}
+
+			message := fmt.Sprintf("Certificate can't be used on %s for target %q: %v", u.Name(), target.Name, err)
+			logger.Info("Certificate does not cover the target", "error", err.Error())
+			r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonHostsNotCovered, message)
+
+			return targetFailed(status, &uploadFailure{
+				Condition: v1alpha1.ConditionCertificateValid,
+				Reason:    ReasonHostsNotCovered,
+				Message:   message,
+			}), nil
+		}
+	}
+
+	// The secret is changed again before the last rollover is completed
+	if status.PreviousCertificateID != "" {
+		if err := r.abortRollover(ctx, req, u, status); err != nil {
+			return r.uploadFailed(ctx, cu, target, status, u, "abort rollover", err)
+		}
+	}
+
+	certID := status.CertificateID
+
+	if certID != "" {
+		if _, err := u.Describe(ctx, req, certID); err != nil {
+			if !errors.Is(err, uploader.ErrNotFound) {
+				return r.uploadFailed(ctx, cu, target, status, u, "get certificate", err)
+			}
+
+			logger.Info("Certificate does not exist on the provider", "certificateId", certID)
+			certID = ""
+		}
+	}
+
+	var (
+		action   string
+		result   *uploader.Certificate
+		rollover = certID != "" && rolloverStrategy(cu.GetUploadSpec()) != nil
+	)
+
+	switch {
+	case rollover:
+		action = "create certificate for rollover"
+		result, err = u.Create(ctx, req)
+	case certID != "":
+		action = "update certificate"
+		result, err = u.Update(ctx, req, certID)
+	default:
+		action = "create certificate"
+		result, err = u.Create(ctx, req)
+	}
+
+	if err != nil {
+		return r.uploadFailed(ctx, cu, target, status, u, action, err)
+	}
+
+	// The certificate is updated in place if the provider can't create
+	// another one, e.g. the certificate ARN of ACM is specified.
+	if rollover && result.ID != certID {
+		startRollover(status, time.Now())
+		logger.Info("Rollover is started", "certificateId", result.ID, "previousCertificateId", certID)
+	}
+
+	// The certificate is replaced if the provider can't update it in place,
+	// e.g. the target is moved to another zone or the certificate is
+	// immutable. The previous one is deleted like after a rollover, so the
+	// deletion is retried until it succeeds.
+	if !rollover && certID != "" && result.ID != certID {
+		now := time.Now()
+		startRollover(status, now)
+		status.PreviousDeleteTime = timePtr(metav1.NewTime(now))
+		logger.Info("Certificate is replaced", "certificateId", result.ID, "previousCertificateId", certID, "previousZoneId", status.PreviousZoneID)
+	}
+
+	status.CertificateID = result.ID
+	status.SecretResourceVersion = source.Secret.ResourceVersion
+	status.SecretFingerprint = source.Fingerprint
+	status.UploadTime = timePtr(metav1.NewTime(result.UploadTime))
+	status.UpdateTime = timePtr(metav1.NewTime(result.UpdateTime))
+	status.ExpireTime = timePtr(metav1.NewTime(result.ExpireTime))
+	status.LastError = ""
+	status.FailedAttempts = 0
+	status.NextRetryTime = nil
+	status.UploadPending = false
+	status.RolledBackFingerprint = ""
+	status.ZoneID = result.ZoneID
+	status.ZoneName = result.ZoneName
+	status.Spec = target.DeepCopy()
+	cu.GetUploadStatus().UploadTime = status.UploadTime
+	cu.GetUploadStatus().UpdateTime = status.UpdateTime
+	cu.GetUploadStatus().ExpireTime = status.ExpireTime
+
+	r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonUploaded, "Uploaded to %s for target %q", u.Name(), target.Name)
+
+	return nil, nil
+}
+
+func (r *CertificateUploadReconciler) uploadFailed(ctx context.Context, cu uploadObject, target *v1alpha1.UploadTarget, status *v1alpha1.UploadTargetStatus, u uploader.Uploader, action string, err error) (*uploadFailure, error) {
+	logger := log.FromContext(ctx).WithValues("target", target.Name, "provider", u.Name())
+	message := fmt.Sprintf("Failed to %s on %s for target %q: %v", action, u.Name(), target.Name, err)
+
+	logger.Error(err, "Failed to "+action)
+
+	if uploader.IsRetryable(err) {
+		delay := retryDelay(status.FailedAttempts+1, uploader.RetryAfter(err))
+		message = fmt.Sprintf("%s, retrying in %v", message, delay)
+		r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonFailed, message)
+
+		failure := targetFailed(status, &uploadFailure{
+			Reason:     ReasonRetrying,
+			Message:    message,
+			RetryAfter: delay,
+		})
+		status.NextRetryTime = timePtr(metav1.NewTime(time.Now().Add(delay)))
+
+		return failure, nil
+	}
+
+	r.EventRecorder.Event(cu, corev1.EventTypeWarning, ReasonFailed, message)
+
+	failure := &uploadFailure{
+		Reason:  ReasonFailed,
+		Message: message,
+	}
+
+	if uploader.IsCredentialsError(err) {
+		failure.Condition = v1alpha1.ConditionCredentialsValid
+		failure.Reason = ReasonInvalidCredentials
+
+		if uploader.IsInsufficientPermissions(err) {
+			failure.Reason = ReasonInsufficientPermissions
+		}
+	}
+
+	return targetFailed(status, failure), nil
+}
+
+func (r *CertificateUploadReconciler) providerFailed(cu uploadObject, target *v1alpha1.UploadTarget, status *v1alpha1.UploadTargetStatus, err error) (*uploadFailure, error) {
+	message := fmt.Sprintf("Failed to get provider of target %q: %v", target.Name, err)
+	reason := ReasonProviderNotFound
+
+	switch {
+	case errors.Is(err, errProviderMismatch):
+		reason = ReasonInvalidProvider
+	case !kerrors.IsNotFound(err):
+		status.LastError = message
+
+		return nil, err
+	}
+
+	r.EventRecorder.Event(cu, corev1.EventTypeWarning, reason, message)
+
+	return targetFailed(status, &uploadFailure{
+		Condition: v1alpha1.ConditionCredentialsValid,
+		Reason:    reason,
+		Message:   message,
+	}), nil
+}
+
+func targetFailed(status *v1alpha1.UploadTargetStatus, failure *uploadFailure) *uploadFailure {
+	status.LastError = failure.Message
+	status.FailedAttempts++
+	status.NextRetryTime = nil
+
+	return failure
+}
+
+// retryDelay returns how long to wait before retrying a target which has
+// failed the number of times. The delay is doubled on every failure, unless
+// the provider asks to wait longer.
+func retryDelay(attempts int32, retryAfter time.Duration) time.Duration {
+	delay := minRetryDelay
+
+	for i := int32(1); i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	if retryAfter > delay {
+		return retryAfter
+	}
+
+	return delay
+}
+
+// checkTargetDrift returns a message describing how the certificate on the
+// provider differs from the secret, or an empty string if they match.
+func (r *CertificateUploadReconciler) checkTargetDrift(ctx context.Context, cu uploadObject, leaf *x509.Certificate, target *v1alpha1.UploadTarget, status *v1alpha1.UploadTargetStatus) (string, error) {
+	if status.CertificateID == "" {
+		return "", nil
+	}
+
+	req, err := r.newRequest(ctx, cu, target)
+	if err != nil {
+		return "", err
+	}
+
+	req.Status = status
+
+	name, u, err := r.Uploaders.Find(req.Target)
+	if err != nil {
+		return "", err
+	}
+
+	if u == nil || name != status.Provider || !r.Uploaders.Enabled(name) {
+		return "", nil
+	}
+
+	remote, err := u.Describe(ctx, req, status.CertificateID)
+	if err != nil {
+		if errors.Is(err, uploader.ErrNotFound) {
+			return fmt.Sprintf("certificate %q does not exist on %s", status.CertificateID, u.Name()), nil
+		}
+
+		return "", fmt.Errorf("failed to get certificate from %s: %w", u.Name(), err)
+	}
+
+	return certificateDrift(remote, leaf), nil
+}
+
+func (r *CertificateUploadReconciler) deleteTarget(ctx context.Context, cu uploadObject, target *v1alpha1.UploadTarget) error {
+	status := findTargetStatus(cu, target.Name)
+
+	if status == nil || status.CertificateID == "" {
+		return nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("target", target.Name)
+
+	req, err := r.newRequest(ctx, cu, target)
+	if err != nil {
+		// The provider is gone, so the certificate can't be deleted
+		if kerrors.IsNotFound(err) {
+			r.deleteSkipped(ctx, cu, status, err, "the provider does not exist")
+
+			return nil
+		}
+
+		if errors.Is(err, errProviderMismatch) {
+			r.deleteSkipped(ctx, cu, status, err, "the provider does not match the target")
+
+			return nil
+		}
+
+		return fmt.Errorf("failed to get provider of target %q: %w", target.Name, err)
+	}
+
+	req.Status = status
+
+	name, u, err := r.Uploaders.Find(req.Target)
+	if err != nil {
+		r.deleteSkipped(ctx, cu, status, err, "the target is invalid")
+
+		return nil
+	}
+
+	// The target is changed to another provider before the certificate is
+	// uploaded again, so the certificate can't be deleted with the target
+	if u == nil || name != status.Provider {
+		r.deleteSkipped(ctx, cu, status, fmt.Errorf("certificate was uploaded to %q", status.Provider), "the target is no longer configured for its provider")
+
+		return nil
+	}
+
+	if !r.Uploaders.Enabled(name) {
+		r.deleteSkipped(ctx, cu, status, fmt.Errorf("provider %q is disabled", name), "the provider is disabled")
+
+		return nil
+	}
+
+	logger = logger.WithValues("provider", u.Name())
+
+	if err := u.Delete(ctx, req, status.CertificateID); err != nil {
+		if errors.Is(err, uploader.ErrNotManaged) {
+			logger.V(1).Info("Skip deleting certificate which is not managed by the controller")
+
+			return nil
+		}
+
+		logger.Error(err, "Failed to delete certificate")
+		r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonDeleteFailed, "Failed to delete certificate on %s for target %q: %v", u.Name(), target.Name, err)
+
+		// Credentials are gone for good, so the certificate can't be deleted.
+		// Don't block the deletion of the resource forever.
+		if uploader.IsCredentialsError(err) && !uploader.IsRetryable(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to delete certificate of target %q: %w", target.Name, err)
+	}
+
+	r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonDeleted, "Deleted certificate %q from %s for target %q", status.CertificateID, u.Name(), target.Name)
+
+	if prevID := status.PreviousCertificateID; prevID != "" {
+		if err := r.deletePreviousCertificate(ctx, req, u, status); err != nil {
+			return fmt.Errorf("failed to delete previous certificate of target %q: %w", target.Name, err)
+		}
+
+		r.EventRecorder.Eventf(cu, corev1.EventTypeNormal, ReasonDeleted, "Deleted certificate %q from %s for target %q", prevID, u.Name(), target.Name)
+	}
+
+	return nil
+}
+
+// deleteSkipped logs and records an event when the certificate of a target is
+// left on the provider, so it can be deleted manually.
+func (r *CertificateUploadReconciler) deleteSkipped(ctx context.Context, cu uploadObject, status *v1alpha1.UploadTargetStatus, err error, reason string) {
+	log.FromContext(ctx).Error(err, "Skip deleting certificate because "+reason, "target", status.Name, "certificateId", status.CertificateID)
+	r.EventRecorder.Eventf(cu, corev1.EventTypeWarning, ReasonDeleteFailed, "Skipped deleting certificate %q for target %q because %s", status.CertificateID, status.Name, reason)
+}
@@ -0,0 +1,815 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/internal/uploader/cloudflaretest"
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts   int32
+		retryAfter time.Duration
+		expected   time.Duration
+	}{
+		{attempts: 0, expected: minRetryDelay},
+		{attempts: 1, expected: minRetryDelay},
+		{attempts: 2, expected: 2 * minRetryDelay},
+		{attempts: 3, expected: 4 * minRetryDelay},
+		{attempts: 100, expected: maxRetryDelay},
+		{attempts: 1, retryAfter: time.Second, expected: minRetryDelay},
+		{attempts: 1, retryAfter: time.Minute, expected: time.Minute},
+		{attempts: 100, retryAfter: time.Hour, expected: time.Hour},
+	}
+
+	for _, test := range tests {
+		if actual := retryDelay(test.attempts, test.retryAfter); actual != test.expected {
+			t.Errorf("retryDelay(%d, %v) = %v, expected %v", test.attempts, test.retryAfter, actual, test.expected)
+		}
+	}
+}
+
+func newTestReconciler(u uploader.Uploader) *CertificateUploadReconciler {
+	uploaders := uploader.NewRegistry()
+	uploaders.Register("fake", u)
+
+	return &CertificateUploadReconciler{
+		EventRecorder: record.NewFakeRecorder(100),
+		Uploaders:     uploaders,
+	}
+}
+
+// expectEvent checks whether an event of the type and the reason containing
+// the text was recorded.
+func expectEvent(t *testing.T, r *CertificateUploadReconciler, eventType, reason, text string) {
+	t.Helper()
+
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+	prefix := eventType + " " + reason + " "
+
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.HasPrefix(event, prefix) && strings.Contains(event, text) {
+				return
+			}
+		default:
+			t.Fatalf("expected a %s event %s containing %s", eventType, reason, text)
+		}
+	}
+}
+
+func newTestSource() *certificateSource {
+	return &certificateSource{
+		Secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", ResourceVersion: "1"},
+		},
+		Fingerprint: "fingerprint",
+	}
+}
+
+func TestUploadTarget(t *testing.T) {
+	ctx := context.Background()
+	target := &v1alpha1.UploadTarget{Name: "foo"}
+
+	t.Run("create", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name, UploadPending: true}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if failure != nil || err != nil {
+			t.Fatalf("unexpected failure: %+v, %v", failure, err)
+		}
+
+		if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Create"}) {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+
+		if status.CertificateID != "cert-1" || status.Provider != "fake" || status.SecretResourceVersion != "1" || status.SecretFingerprint != "fingerprint" || status.UploadPending {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		if _, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status); err != nil {
+			t.Fatal(err)
+		}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if failure != nil || err != nil {
+			t.Fatalf("unexpected failure: %+v, %v", failure, err)
+		}
+
+		if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Create", "Describe cert-1", "Update cert-1"}) {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+
+		if status.CertificateID != "cert-1" {
+			t.Fatalf("unexpected certificate ID: %s", status.CertificateID)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{
+			Name:          target.Name,
+			Provider:      "fake",
+			CertificateID: "deleted",
+		}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if failure != nil || err != nil {
+			t.Fatalf("unexpected failure: %+v, %v", failure, err)
+		}
+
+		// The certificate is created again if it was deleted on the provider
+		if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Describe deleted", "Create"}) {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+
+		if status.CertificateID != "cert-1" {
+			t.Fatalf("unexpected certificate ID: %s", status.CertificateID)
+		}
+	})
+
+	t.Run("moved to another zone", func(t *testing.T) {
+		u := &fake.Uploader{ZoneID: "a"}
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		if _, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status); err != nil {
+			t.Fatal(err)
+		}
+
+		u.ZoneID = "b"
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if failure != nil || err != nil {
+			t.Fatalf("unexpected failure: %+v, %v", failure, err)
+		}
+
+		// The previous certificate is deleted later
+		if status.CertificateID != "cert-2" || status.ZoneID != "b" || status.PreviousCertificateID != "cert-1" || status.PreviousZoneID != "a" || status.PreviousDeleteTime == nil {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+
+		if u.Certificate("cert-1") == nil {
+			t.Fatal("previous certificate is deleted")
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		u := &fake.Uploader{Immutable: true}
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		for i := 0; i < 2; i++ {
+			failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+			if failure != nil || err != nil {
+				t.Fatalf("unexpected failure: %+v, %v", failure, err)
+			}
+		}
+
+		// The previous certificate is deleted later
+		if status.CertificateID != "cert-2" || status.PreviousCertificateID != "cert-1" || status.PreviousDeleteTime == nil {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+
+		if u.Certificate("cert-1") == nil {
+			t.Fatal("previous certificate is deleted")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		r.Uploaders.SetEnabled("fake", false)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failure == nil || failure.Reason != ReasonProviderDisabled {
+			t.Fatalf("unexpected failure: %+v", failure)
+		}
+
+		if calls := u.Calls(); len(calls) > 0 {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+	})
+
+	t.Run("multiple providers", func(t *testing.T) {
+		u := new(fake.Uploader)
+		other := new(fake.Uploader)
+		r := newTestReconciler(u)
+		r.Uploaders.Register("other", other)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failure == nil || failure.Reason != ReasonMultipleProviders {
+			t.Fatalf("unexpected failure: %+v", failure)
+		}
+
+		if len(u.Calls()) != 0 || len(other.Calls()) != 0 {
+			t.Fatalf("unexpected calls: %v, %v", u.Calls(), other.Calls())
+		}
+	})
+
+	t.Run("retryable error", func(t *testing.T) {
+		u := &fake.Uploader{
+			Err: &uploader.RetryableError{Err: errors.New("unavailable"), RetryAfter: time.Minute},
+		}
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		failure, err := r.uploadTarget(ctx, new(v1alpha1.CertificateUpload), newTestSource(), target, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failure == nil || failure.Reason != ReasonRetrying || failure.RetryAfter != time.Minute {
+			t.Fatalf("unexpected failure: %+v", failure)
+		}
+
+		if status.FailedAttempts != 1 || status.NextRetryTime == nil || status.CertificateID != "" {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+	})
+
+	t.Run("provider mismatch", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		if err := v1alpha1.AddToScheme(scheme); err != nil {
+			t.Fatal(err)
+		}
+
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		r.Client = fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Provider{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "acm"},
+			Spec:       v1alpha1.ProviderSpec{ACM: &v1alpha1.ACMUploadSpec{Region: "us-east-1"}},
+		}).Build()
+
+		cu := &v1alpha1.CertificateUpload{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+		target := &v1alpha1.UploadTarget{
+			Name:        "foo",
+			ProviderRef: &v1alpha1.ProviderReference{Kind: v1alpha1.ProviderKind, Name: "acm"},
+			Cloudflare:  &v1alpha1.CloudflareUploadSpec{ZoneName: "example.com"},
+		}
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+		failure, err := r.uploadTarget(ctx, cu, newTestSource(), target, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failure == nil || failure.Reason != ReasonInvalidProvider {
+			t.Fatalf("unexpected failure: %+v", failure)
+		}
+
+		if len(u.Calls()) != 0 {
+			t.Fatalf("unexpected calls: %v", u.Calls())
+		}
+	})
+}
+
+func TestCloudflareZoneMove(t *testing.T) {
+	ctx := context.Background()
+	api := cloudflaretest.NewServer()
+	defer api.Close()
+
+	api.AddZone("shop-zone", "shop.example.com")
+
+	uploaders := uploader.NewRegistry()
+	uploaders.Register("cloudflare", &uploader.Cloudflare{
+		Client: fakeclient.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cloudflare"},
+			Data:       map[string][]byte{"token": []byte(cloudflaretest.APIToken)},
+		}).Build(),
+		BaseURL: api.URL(),
+	})
+
+	r := &CertificateUploadReconciler{
+		EventRecorder: record.NewFakeRecorder(100),
+		Uploaders:     uploaders,
+	}
+
+	now := time.Now()
+	leaf := newTestCertificate(t, "www.shop.example.com", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	source := &certificateSource{
+		Secret:      newTestSecret(t, leaf.Key, leaf),
+		Leaf:        leaf.Cert,
+		Fingerprint: "fingerprint",
+	}
+	cu := &v1alpha1.CertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+	}
+	target := &v1alpha1.UploadTarget{
+		Name: "foo",
+		Cloudflare: &v1alpha1.CloudflareUploadSpec{
+			ZoneName: cloudflaretest.ZoneName,
+			APITokenSecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "cloudflare"},
+				Key:                  "token",
+			},
+		},
+	}
+	status := &v1alpha1.UploadTargetStatus{Name: target.Name}
+
+	if failure, err := r.uploadTarget(ctx, cu, source, target, status); failure != nil || err != nil {
+		t.Fatalf("unexpected failure: %+v, %v", failure, err)
+	}
+
+	prevID := status.CertificateID
+
+	if status.ZoneID != cloudflaretest.ZoneID || api.Certificate(prevID) == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The certificate is created in the new zone and the previous one is
+	// deleted later
+	target.Cloudflare.ZoneName = "shop.example.com"
+
+	if failure, err := r.uploadTarget(ctx, cu, source, target, status); failure != nil || err != nil {
+		t.Fatalf("unexpected failure: %+v, %v", failure, err)
+	}
+
+	certID := status.CertificateID
+
+	if certID == prevID || status.ZoneID != "shop-zone" || status.PreviousCertificateID != prevID || status.PreviousZoneID != cloudflaretest.ZoneID || status.PreviousDeleteTime == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	if api.Certificate(certID) == nil || api.Certificate(prevID) == nil {
+		t.Fatalf("expected certificates %s and %s to exist", certID, prevID)
+	}
+
+	// Failed deletes of the previous certificate are retried
+	api.FailDeletes(cloudflaretest.ZoneID, true)
+
+	_, failure, err := r.continueRollover(ctx, cu, target, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failure == nil || status.PreviousCertificateID != prevID || status.PreviousDeleteTime == nil || status.FailedAttempts != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	if api.Certificate(prevID) == nil {
+		t.Fatalf("certificate %s is deleted", prevID)
+	}
+
+	api.FailDeletes(cloudflaretest.ZoneID, false)
+
+	if _, failure, err := r.continueRollover(ctx, cu, target, status); failure != nil || err != nil {
+		t.Fatalf("unexpected failure: %+v, %v", failure, err)
+	}
+
+	if status.CertificateID != certID || status.PreviousCertificateID != "" || status.PreviousDeleteTime != nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	if api.Certificate(prevID) != nil {
+		t.Fatalf("certificate %s is not deleted", prevID)
+	}
+
+	if api.Certificate(certID) == nil {
+		t.Fatalf("certificate %s is deleted", certID)
+	}
+}
+
+func TestLegacyTargetStatus(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		target v1alpha1.UploadTarget
+		status v1alpha1.CertificateUploadStatus
+	}{
+		{
+			name:   "cloudflare",
+			target: v1alpha1.UploadTarget{Name: legacyCloudflareTarget, Cloudflare: &v1alpha1.CloudflareUploadSpec{}},
+			status: v1alpha1.CertificateUploadStatus{
+				SecretResourceVersion: "0",
+				Cloudflare:            &v1alpha1.CloudflareUploadStatus{CertificateID: "cert-1"},
+			},
+		},
+		{
+			name:   "acm",
+			target: v1alpha1.UploadTarget{Name: legacyACMTarget, ACM: &v1alpha1.ACMUploadSpec{}},
+			status: v1alpha1.CertificateUploadStatus{
+				SecretResourceVersion: "0",
+				ACM:                   &v1alpha1.ACMUploadStatus{CertificateARN: "cert-1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			u := new(fake.Uploader)
+			r := newTestReconciler(u)
+			r.Uploaders = uploader.NewRegistry()
+			r.Uploaders.Register(test.target.Name, u)
+
+			// The certificate uploaded by an older version
+			if _, err := u.Create(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			cu := &v1alpha1.CertificateUpload{Status: test.status}
+			status := targetStatus(cu, test.target.Name)
+
+			if status.Provider != test.target.Name || status.CertificateID != "cert-1" || status.SecretResourceVersion != "0" {
+				t.Fatalf("unexpected migrated status: %+v", status)
+			}
+
+			failure, err := r.uploadTarget(ctx, cu, newTestSource(), &test.target, status)
+			if failure != nil || err != nil {
+				t.Fatalf("unexpected failure: %+v, %v", failure, err)
+			}
+
+			if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Create", "Describe cert-1", "Update cert-1"}) {
+				t.Fatalf("unexpected calls: %v", calls)
+			}
+
+			if status.CertificateID != "cert-1" {
+				t.Fatalf("unexpected certificate ID: %s", status.CertificateID)
+			}
+		})
+	}
+}
+
+func TestRemoveTargets(t *testing.T) {
+	ctx := context.Background()
+	target := v1alpha1.UploadTarget{Name: "removed"}
+
+	newUpload := func(t *testing.T, u *fake.Uploader) *v1alpha1.CertificateUpload {
+		cert, err := u.Create(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &v1alpha1.CertificateUpload{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+			Status: v1alpha1.CertificateUploadStatus{
+				Targets: []v1alpha1.UploadTargetStatus{
+					{Name: "kept", Provider: "fake"},
+					{Name: target.Name, Provider: "fake", CertificateID: cert.ID, Spec: target.DeepCopy()},
+				},
+			},
+		}
+	}
+
+	kept := []v1alpha1.UploadTarget{{Name: "kept"}}
+
+	t.Run("delete", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		cu := newUpload(t, u)
+
+		failures, err := r.removeTargets(ctx, cu, kept)
+		if len(failures) > 0 || err != nil {
+			t.Fatalf("unexpected failures: %+v, %v", failures, err)
+		}
+
+		if u.Certificate("cert-1") != nil {
+			t.Fatal("certificate of the removed target is not deleted")
+		}
+
+		if len(cu.Status.Targets) != 1 || cu.Status.Targets[0].Name != "kept" {
+			t.Fatalf("unexpected statuses: %+v", cu.Status.Targets)
+		}
+	})
+
+	t.Run("retain", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		cu := newUpload(t, u)
+		cu.Spec.DeletionPolicy = v1alpha1.DeletionPolicyRetain
+
+		if _, err := r.removeTargets(ctx, cu, kept); err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Certificate("cert-1") == nil {
+			t.Fatal("certificate of the removed target is deleted")
+		}
+
+		if len(cu.Status.Targets) != 1 {
+			t.Fatalf("unexpected statuses: %+v", cu.Status.Targets)
+		}
+	})
+
+	t.Run("retry failed deletion", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		cu := newUpload(t, u)
+		u.Err = errors.New("unavailable")
+
+		failures, err := r.removeTargets(ctx, cu, kept)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if len(failures) != 1 || failures[0].Reason != ReasonDeleteFailed {
+			t.Fatalf("unexpected failures: %+v", failures)
+		}
+
+		// The status is kept until the certificate is deleted
+		if status := findTargetStatus(cu, target.Name); status == nil || status.LastError == "" {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+
+		u.Err = nil
+
+		if failures, err := r.removeTargets(ctx, cu, kept); len(failures) > 0 || err != nil {
+			t.Fatalf("unexpected failures: %+v, %v", failures, err)
+		}
+
+		if u.Certificate("cert-1") != nil || findTargetStatus(cu, target.Name) != nil {
+			t.Fatal("certificate of the removed target is not deleted")
+		}
+	})
+
+	t.Run("provider changed", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		cu := newUpload(t, u)
+		cu.Status.Targets[1].Provider = "other"
+
+		if failures, err := r.removeTargets(ctx, cu, kept); len(failures) > 0 || err != nil {
+			t.Fatalf("unexpected failures: %+v, %v", failures, err)
+		}
+
+		if u.Certificate("cert-1") == nil {
+			t.Fatal("certificate uploaded to another provider is deleted")
+		}
+
+		// The skipped certificate is reported, so it can be deleted manually
+		expectEvent(t, r, corev1.EventTypeWarning, ReasonDeleteFailed, `"cert-1"`)
+	})
+
+	t.Run("provider disabled", func(t *testing.T) {
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		r.Uploaders.SetEnabled("fake", false)
+		cu := newUpload(t, u)
+
+		if failures, err := r.removeTargets(ctx, cu, kept); len(failures) > 0 || err != nil {
+			t.Fatalf("unexpected failures: %+v, %v", failures, err)
+		}
+
+		if u.Certificate("cert-1") == nil {
+			t.Fatal("certificate on the disabled provider is deleted")
+		}
+
+		expectEvent(t, r, corev1.EventTypeWarning, ReasonDeleteFailed, "disabled")
+	})
+
+	t.Run("finalize", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		if err := v1alpha1.AddToScheme(scheme); err != nil {
+			t.Fatal(err)
+		}
+
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		cu := newUpload(t, u)
+		cu.Finalizers = []string{FinalizerName}
+		r.Client = fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(cu.DeepCopy()).Build()
+
+		// Certificates of removed targets which failed to be deleted are
+		// deleted with the resource
+		if _, err := r.finalize(ctx, cu); err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Certificate("cert-1") != nil {
+			t.Fatal("certificate of the removed target is not deleted")
+		}
+	})
+}
+
+func TestCheckTargetDrift(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	leaf := newTestCertificate(t, "example.com", now.Add(-time.Hour), now.Add(time.Hour), nil).Cert
+	target := &v1alpha1.UploadTarget{Name: "foo"}
+
+	tests := []struct {
+		name    string
+		remote  *uploader.Certificate
+		drifted bool
+	}{
+		{
+			name: "match",
+			remote: &uploader.Certificate{
+				ID:           "cert-1",
+				ExpireTime:   leaf.NotAfter,
+				SerialNumber: leaf.SerialNumber.Text(16),
+				Hosts:        leaf.DNSNames,
+			},
+		},
+		{
+			name: "serial number changed",
+			remote: &uploader.Certificate{
+				ID:           "cert-1",
+				SerialNumber: "1234",
+			},
+			drifted: true,
+		},
+		{
+			name: "hosts changed",
+			remote: &uploader.Certificate{
+				ID:    "cert-1",
+				Hosts: []string{"www.example.com"},
+			},
+			drifted: true,
+		},
+		{
+			name:    "not found",
+			drifted: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			u := new(fake.Uploader)
+			r := newTestReconciler(u)
+			status := &v1alpha1.UploadTargetStatus{Name: target.Name, Provider: "fake", CertificateID: "cert-1"}
+
+			if test.remote != nil {
+				u.Put(test.remote)
+			}
+
+			drift, err := r.checkTargetDrift(ctx, new(v1alpha1.CertificateUpload), leaf, target, status)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := drift != ""; actual != test.drifted {
+				t.Fatalf("expected drifted to be %v, got %q", test.drifted, drift)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		u := &fake.Uploader{Err: errors.New("unavailable")}
+		r := newTestReconciler(u)
+		status := &v1alpha1.UploadTargetStatus{Name: target.Name, Provider: "fake", CertificateID: "cert-1"}
+
+		if _, err := r.checkTargetDrift(ctx, new(v1alpha1.CertificateUpload), leaf, target, status); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestResyncDrift(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", now.Add(-time.Hour), now.Add(time.Hour), nil)
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	newUpload := func(t *testing.T, policy v1alpha1.DriftPolicy) (*CertificateUploadReconciler, *fake.Uploader, *v1alpha1.CertificateUpload) {
+		secret := newTestSecret(t, cert.Key, cert)
+		secret.Namespace = "default"
+		c := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+		if err := c.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+			t.Fatal(err)
+		}
+
+		u := new(fake.Uploader)
+		r := newTestReconciler(u)
+		r.Client = c
+		r.ResyncInterval = time.Hour
+
+		remote, err := u.Create(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cu := &v1alpha1.CertificateUpload{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+			Spec: v1alpha1.CertificateUploadSpec{
+				SecretName:  secret.Name,
+				DriftPolicy: policy,
+				Targets:     []v1alpha1.UploadTarget{{Name: "foo"}},
+			},
+			Status: v1alpha1.CertificateUploadStatus{
+				Targets: []v1alpha1.UploadTargetStatus{
+					{
+						Name:                  "foo",
+						Provider:              "fake",
+						CertificateID:         remote.ID,
+						SecretResourceVersion: secret.ResourceVersion,
+						SecretFingerprint:     secretFingerprint(secret),
+					},
+				},
+			},
+		}
+
+		return r, u, cu
+	}
+
+	t.Run("in sync", func(t *testing.T) {
+		r, u, cu := newUpload(t, v1alpha1.DriftPolicyReport)
+		u.Put(&uploader.Certificate{ID: "cert-1", Hosts: cert.Cert.DNSNames})
+
+		if _, err := r.uploadCertificate(ctx, cu); err != nil {
+			t.Fatal(err)
+		}
+
+		if cond := meta.FindStatusCondition(cu.Status.Conditions, v1alpha1.ConditionDrifted); cond == nil || cond.Status != metav1.ConditionFalse {
+			t.Fatalf("unexpected condition: %+v", cond)
+		}
+	})
+
+	t.Run("report", func(t *testing.T) {
+		r, u, cu := newUpload(t, v1alpha1.DriftPolicyReport)
+		u.Put(&uploader.Certificate{ID: "cert-1", Hosts: []string{"www.example.com"}})
+
+		if _, err := r.uploadCertificate(ctx, cu); err != nil {
+			t.Fatal(err)
+		}
+
+		if cond := meta.FindStatusCondition(cu.Status.Conditions, v1alpha1.ConditionDrifted); cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != ReasonDrifted {
+			t.Fatalf("unexpected condition: %+v", cond)
+		}
+
+		if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Create", "Describe cert-1"}) {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		r, u, cu := newUpload(t, v1alpha1.DriftPolicyRepair)
+		u.Put(&uploader.Certificate{ID: "cert-1", SerialNumber: "1234"})
+
+		if _, err := r.uploadCertificate(ctx, cu); err != nil {
+			t.Fatal(err)
+		}
+
+		if cond := meta.FindStatusCondition(cu.Status.Conditions, v1alpha1.ConditionDrifted); cond == nil || cond.Status != metav1.ConditionFalse {
+			t.Fatalf("unexpected condition: %+v", cond)
+		}
+
+		if calls := u.Calls(); !reflect.DeepEqual(calls, []string{"Create", "Describe cert-1", "Describe cert-1", "Update cert-1"}) {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+	})
+
+	t.Run("deleted on provider", func(t *testing.T) {
+		r, u, cu := newUpload(t, v1alpha1.DriftPolicyReport)
+
+		if err := u.Delete(ctx, nil, "cert-1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.uploadCertificate(ctx, cu); err != nil {
+			t.Fatal(err)
+		}
+
+		if cond := meta.FindStatusCondition(cu.Status.Conditions, v1alpha1.ConditionDrifted); cond == nil || cond.Status != metav1.ConditionTrue {
+			t.Fatalf("unexpected condition: %+v", cond)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrMissingACMSecretAccessKey = errors.New("accessKeyIdSecretRef and secretAccessKeySecretRef must be set together")
+
+// ACM imports certificates to AWS Certificate Manager.
+type ACM struct {
+	Client client.Client
+
+	clients clientCache
+}
+
+func (a *ACM) Name() string {
+	return "ACM"
+}
+
+func (a *ACM) Configured(target *v1alpha1.UploadTarget) bool {
+	return target.ACM != nil
+}
+
+func (a *ACM) newClient(ctx context.Context, req *Request) (acmiface.ACMAPI, error) {
+	spec := req.Target.ACM
+	config := aws.NewConfig().WithRegion(spec.Region)
+	params := []string{spec.Region, spec.Endpoint}
+
+	if spec.Endpoint != "" {
+		config = config.WithEndpoint(spec.Endpoint)
+	}
+
+	if spec.AccessKeyIDSecretRef != nil || spec.SecretAccessKeySecretRef != nil {
+		if spec.AccessKeyIDSecretRef == nil || spec.SecretAccessKeySecretRef == nil {
+			return nil, &CredentialsError{Err: ErrMissingACMSecretAccessKey}
+		}
+
+		accessKeyID, err := getSecretValue(ctx, a.Client, req.Namespace, spec.AccessKeyIDSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get access key ID: %w", err)
+		}
+
+		secretAccessKey, err := getSecretValue(ctx, a.Client, req.Namespace, spec.SecretAccessKeySecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret access key: %w", err)
+		}
+
+		config = config.WithCredentials(credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""))
+		params = append(params, accessKeyID, secretAccessKey)
+	}
+
+	api, err := a.clients.get(req.CacheKey, params, func() (interface{}, error) {
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create aws session: %w", err)
+		}
+
+		return acm.New(sess), nil
+	})
+	if err != nil {
+		return nil, &CredentialsError{Err: err}
+	}
+
+	return api.(acmiface.ACMAPI), nil
+}
+
+// Ping checks whether the ACM endpoint of the region in AWS_REGION is
+// reachable. The region defaults to us-east-1.
+func (a *ACM) Ping(ctx context.Context) error {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = endpoints.UsEast1RegionID
+	}
+
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(acm.EndpointsID, region)
+	if err != nil {
+		return fmt.Errorf("failed to resolve endpoint: %w", err)
+	}
+
+	return ping(ctx, endpoint.URL)
+}
+
+// Create imports a new certificate, or reimports into the certificate
+// specified in the target.
+func (a *ACM) Create(ctx context.Context, req *Request) (*Certificate, error) {
+	return a.importCertificate(ctx, req, req.Target.ACM.CertificateARN)
+}
+
+func (a *ACM) Update(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	return a.importCertificate(ctx, req, id)
+}
+
+func (a *ACM) importCertificate(ctx context.Context, req *Request, arn string) (*Certificate, error) {
+	api, err := a.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, chain, err := splitCertificateChain(req.Secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	input := &acm.ImportCertificateInput{
+		Certificate: leaf,
+		PrivateKey:  req.Secret.Data[corev1.TLSPrivateKeyKey],
+	}
+
+	if len(chain) > 0 {
+		input.CertificateChain = chain
+	}
+
+	// Tags can only be set on the first import
+	if arn != "" {
+		input.CertificateArn = aws.String(arn)
+	} else {
+		input.Tags = acmTags(req.Target.ACM.Tags)
+	}
+
+	output, err := api.ImportCertificateWithContext(ctx, input)
+	if err != nil {
+		return nil, acmError(fmt.Errorf("failed to import certificate: %w", err))
+	}
+
+	arn = aws.StringValue(output.CertificateArn)
+
+	if input.CertificateArn != nil && len(req.Target.ACM.Tags) > 0 {
+		_, err := api.AddTagsToCertificateWithContext(ctx, &acm.AddTagsToCertificateInput{
+			CertificateArn: aws.String(arn),
+			Tags:           acmTags(req.Target.ACM.Tags),
+		})
+		if err != nil {
+			return nil, acmError(fmt.Errorf("failed to tag certificate: %w", err))
+		}
+	}
+
+	return a.describe(ctx, api, arn)
+}
+
+func (a *ACM) Describe(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	api, err := a.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.describe(ctx, api, id)
+}
+
+func (a *ACM) describe(ctx context.Context, api acmiface.ACMAPI, arn string) (*Certificate, error) {
+	output, err := api.DescribeCertificateWithContext(ctx, &acm.DescribeCertificateInput{
+		CertificateArn: aws.String(arn),
+	})
+	if err != nil {
+		if isAWSErrorCode(err, acm.ErrCodeResourceNotFoundException) {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		return nil, acmError(fmt.Errorf("failed to describe certificate: %w", err))
+	}
+
+	cert := &Certificate{ID: arn}
+
+	if detail := output.Certificate; detail != nil {
+		cert.UploadTime = aws.TimeValue(detail.ImportedAt)
+		cert.UpdateTime = aws.TimeValue(detail.ImportedAt)
+		cert.ExpireTime = aws.TimeValue(detail.NotAfter)
+		cert.Hosts = aws.StringValueSlice(detail.SubjectAlternativeNames)
+		cert.SerialNumber = aws.StringValue(detail.Serial)
+		cert.State = acmState(aws.StringValue(detail.Status))
+	}
+
+	return cert, nil
+}
+
+func (a *ACM) Delete(ctx context.Context, req *Request, id string) error {
+	// Certificates specified in the target were not imported by the controller
+	if id == req.Target.ACM.CertificateARN {
+		return fmt.Errorf("%w: %s", ErrNotManaged, id)
+	}
+
+	api, err := a.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.DeleteCertificateWithContext(ctx, &acm.DeleteCertificateInput{
+		CertificateArn: aws.String(id),
+	})
+
+	if err != nil && !isAWSErrorCode(err, acm.ErrCodeResourceNotFoundException) {
+		return acmError(fmt.Errorf("failed to delete certificate: %w", err))
+	}
+
+	return nil
+}
+
+func acmState(status string) CertificateState {
+	switch status {
+	case "":
+		return ""
+	case acm.CertificateStatusIssued:
+		return CertificateStateActive
+	case acm.CertificateStatusPendingValidation:
+		return CertificateStatePending
+	}
+
+	return CertificateStateFailed
+}
+
+func acmTags(tags map[string]string) []*acm.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(tags))
+
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	result := make([]*acm.Tag, len(keys))
+
+	for i, k := range keys {
+		result[i] = &acm.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		}
+	}
+
+	return result
+}
+
+// acmError marks errors which may succeed later, e.g. throttling and server
+// errors, as retryable.
+func acmError(err error) error {
+	var awsErr awserr.Error
+
+	if errors.As(err, &awsErr) && (request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)) {
+		return &RetryableError{Err: err}
+	}
+
+	return err
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
@@ -0,0 +1,341 @@
+package uploader_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type acmTag struct {
+	Key   string
+	Value string
+}
+
+type acmCertificate struct {
+	Certificate      []byte
+	CertificateChain []byte
+	Tags             []acmTag
+	Imports          int
+}
+
+// fakeACM is a fake ACM API which stores certificates in memory.
+type fakeACM struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	certificates map[string]*acmCertificate
+	lastID       int
+}
+
+func newFakeACM() *fakeACM {
+	f := &fakeACM{certificates: map[string]*acmCertificate{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+
+	return f
+}
+
+func (f *fakeACM) Close() {
+	f.server.Close()
+}
+
+func (f *fakeACM) certificate(arn string) *acmCertificate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.certificates[arn]
+}
+
+func (f *fakeACM) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body struct {
+		CertificateArn   string
+		Certificate      []byte
+		CertificateChain []byte
+		Tags             []acmTag
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "CertificateManager.")
+	cert := f.certificates[body.CertificateArn]
+
+	if operation != "ImportCertificate" || body.CertificateArn != "" {
+		if cert == nil {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, `{"__type":"ResourceNotFoundException","message":"%s not found"}`, body.CertificateArn)
+
+			return
+		}
+	}
+
+	var response interface{} = struct{}{}
+
+	switch operation {
+	case "ImportCertificate":
+		arn := body.CertificateArn
+
+		if cert == nil {
+			f.lastID++
+			arn = fmt.Sprintf("arn:aws:acm:us-east-1:000000000000:certificate/%d", f.lastID)
+			cert = &acmCertificate{Tags: body.Tags}
+			f.certificates[arn] = cert
+		} else if len(body.Tags) > 0 {
+			// ACM doesn't allow tags when a certificate is reimported
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ValidationException","message":"tags can't be set when reimporting"}`))
+
+			return
+		}
+
+		cert.Certificate = body.Certificate
+		cert.CertificateChain = body.CertificateChain
+		cert.Imports++
+		response = map[string]string{"CertificateArn": arn}
+
+	case "AddTagsToCertificate":
+		cert.Tags = append(cert.Tags, body.Tags...)
+
+	case "DescribeCertificate":
+		response = map[string]interface{}{
+			"Certificate": map[string]interface{}{
+				"CertificateArn":          body.CertificateArn,
+				"Status":                  "ISSUED",
+				"Serial":                  "01:02",
+				"SubjectAlternativeNames": []string{"example.com"},
+				"ImportedAt":              1577836800,
+				"NotAfter":                1609459200,
+			},
+		}
+
+	case "DeleteCertificate":
+		delete(f.certificates, body.CertificateArn)
+
+	default:
+		http.Error(w, "unknown operation "+operation, http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newACMRequest(endpoint string, tags map[string]string) *uploader.Request {
+	secretRef := func(key string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "aws"},
+			Key:                  key,
+		}
+	}
+
+	return &uploader.Request{
+		Namespace: "default",
+		Target: &v1alpha1.UploadTarget{
+			Name: "acm",
+			ACM: &v1alpha1.ACMUploadSpec{
+				Region:                   "us-east-1",
+				Endpoint:                 endpoint,
+				AccessKeyIDSecretRef:     secretRef("id"),
+				SecretAccessKeySecretRef: secretRef("secret"),
+				Tags:                     tags,
+			},
+		},
+		Secret: &corev1.Secret{
+			Data: map[string][]byte{
+				corev1.TLSCertKey: append(
+					pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("leaf")}),
+					pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("ca")})...,
+				),
+				corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")}),
+			},
+		},
+	}
+}
+
+func newACM() *uploader.ACM {
+	return &uploader.ACM{
+		Client: fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "aws"},
+			Data: map[string][]byte{
+				"id":     []byte("access-key-id"),
+				"secret": []byte("secret-access-key"),
+			},
+		}).Build(),
+	}
+}
+
+func TestACM(t *testing.T) {
+	ctx := context.Background()
+	api := newFakeACM()
+	defer api.Close()
+
+	a := newACM()
+	tags := map[string]string{"b": "2", "a": "1"}
+	expectedTags := []acmTag{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}
+
+	t.Run("import", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, tags))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cert.State != uploader.CertificateStateActive || cert.SerialNumber != "01:02" || cert.ExpireTime.Year() != 2021 {
+			t.Fatalf("unexpected certificate: %+v", cert)
+		}
+
+		stored := api.certificate(cert.ID)
+		if stored == nil {
+			t.Fatalf("certificate %s is not imported", cert.ID)
+		}
+
+		// The leaf certificate is imported separately from the chain
+		if block, _ := pem.Decode(stored.Certificate); string(block.Bytes) != "leaf" {
+			t.Fatalf("unexpected certificate: %s", stored.Certificate)
+		}
+
+		if block, _ := pem.Decode(stored.CertificateChain); string(block.Bytes) != "ca" {
+			t.Fatalf("unexpected chain: %s", stored.CertificateChain)
+		}
+
+		if !reflect.DeepEqual(stored.Tags, expectedTags) {
+			t.Fatalf("unexpected tags: %v", stored.Tags)
+		}
+	})
+
+	t.Run("reimport with tags", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updated, err := a.Update(ctx, newACMRequest(api.server.URL, tags), cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.ID != cert.ID {
+			t.Fatalf("expected certificate %s to be reimported, got %s", cert.ID, updated.ID)
+		}
+
+		// Tags are added after reimporting
+		stored := api.certificate(cert.ID)
+		if stored.Imports != 2 || !reflect.DeepEqual(stored.Tags, expectedTags) {
+			t.Fatalf("unexpected certificate: %+v", stored)
+		}
+	})
+
+	t.Run("import into specified certificate", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := newACMRequest(api.server.URL, nil)
+		req.Target.ACM.CertificateARN = cert.ID
+
+		created, err := a.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created.ID != cert.ID || api.certificate(cert.ID).Imports != 2 {
+			t.Fatalf("expected certificate %s to be reimported, got %s", cert.ID, created.ID)
+		}
+	})
+
+	t.Run("describe", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		described, err := a.Describe(ctx, newACMRequest(api.server.URL, nil), cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if described.ID != cert.ID || !reflect.DeepEqual(described.Hosts, []string{"example.com"}) {
+			t.Fatalf("unexpected certificate: %+v", described)
+		}
+	})
+
+	t.Run("describe not found", func(t *testing.T) {
+		_, err := a.Describe(ctx, newACMRequest(api.server.URL, nil), "arn:aws:acm:us-east-1:000000000000:certificate/missing")
+
+		if !errors.Is(err, uploader.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.Delete(ctx, newACMRequest(api.server.URL, nil), cert.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if api.certificate(cert.ID) != nil {
+			t.Fatalf("certificate %s is not deleted", cert.ID)
+		}
+
+		// Deleting a missing certificate succeeds
+		if err := a.Delete(ctx, newACMRequest(api.server.URL, nil), cert.ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("delete specified certificate", func(t *testing.T) {
+		cert, err := a.Create(ctx, newACMRequest(api.server.URL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := newACMRequest(api.server.URL, nil)
+		req.Target.ACM.CertificateARN = cert.ID
+
+		// Certificates specified in the target were not imported by the
+		// controller
+		if err := a.Delete(ctx, req, cert.ID); !errors.Is(err, uploader.ErrNotManaged) {
+			t.Fatalf("expected ErrNotManaged, got %v", err)
+		}
+
+		if api.certificate(cert.ID) == nil {
+			t.Fatalf("certificate %s is deleted", cert.ID)
+		}
+	})
+
+	t.Run("missing secret key", func(t *testing.T) {
+		req := newACMRequest(api.server.URL, nil)
+		req.Target.ACM.SecretAccessKeySecretRef.Key = "missing"
+
+		_, err := a.Create(ctx, req)
+
+		if !uploader.IsCredentialsError(err) || !errors.Is(err, uploader.ErrMissingSecretKey) || !strings.Contains(err.Error(), `"missing"`) {
+			t.Fatalf("expected a credentials error naming the missing key, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// clientCache caches API clients by key. A client is re-created when the
+// parameters it was created with are changed, e.g. the secret containing
+// credentials is rotated. The zero value is ready to use.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+type cachedClient struct {
+	hash   string
+	client interface{}
+}
+
+// get returns the cached client of the key, or creates a new one if the key is
+// empty or the parameters are changed.
+func (c *clientCache) get(key string, params []string, create func() (interface{}, error)) (interface{}, error) {
+	if key == "" {
+		return create()
+	}
+
+	hash := paramsHash(params)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clients[key]; ok && cached.hash == hash {
+		return cached.client, nil
+	}
+
+	client, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.clients == nil {
+		c.clients = map[string]*cachedClient{}
+	}
+
+	c.clients[key] = &cachedClient{
+		hash:   hash,
+		client: client,
+	}
+
+	return client, nil
+}
+
+func paramsHash(params []string) string {
+	h := sha256.New()
+
+	for _, s := range params {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,428 @@
+package uploader
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	ErrMissingCloudflareToken  = errors.New("either apiTokenSecretRef or apiKeySecretRef is required for cloudflare")
+	ErrMissingCloudflareEmail  = errors.New("email is required")
+	ErrInactiveCloudflareToken = errors.New("api token is not active")
+)
+
+const (
+	cloudflareStatusPrefix = "HTTP status "
+	cloudflareAPIURL       = "https://api.cloudflare.com/client/v4"
+)
+
+// Cloudflare uploads certificates as custom certificates of a Cloudflare zone.
+type Cloudflare struct {
+	Client client.Client
+
+	// BaseURL overrides the URL of the Cloudflare API. It is used in tests.
+	BaseURL string
+
+	clients clientCache
+	zones   zoneCache
+}
+
+// cloudflareClient is an API client and a hash of its credentials.
+type cloudflareClient struct {
+	*cloudflare.API
+
+	credentials string
+}
+
+func (c *Cloudflare) Name() string {
+	return "Cloudflare"
+}
+
+func (c *Cloudflare) Configured(target *v1alpha1.UploadTarget) bool {
+	return target.Cloudflare != nil
+}
+
+func (c *Cloudflare) newClient(ctx context.Context, req *Request) (*cloudflareClient, error) {
+	spec := req.Target.Cloudflare
+
+	var (
+		params []string
+		create func() (*cloudflare.API, error)
+	)
+
+	switch {
+	case spec.APITokenSecretRef != nil:
+		token, err := getSecretValue(ctx, c.Client, req.Namespace, spec.APITokenSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get api token: %w", err)
+		}
+
+		params = []string{"token", token}
+		create = func() (*cloudflare.API, error) {
+			return cloudflare.NewWithAPIToken(token, cloudflareOptions()...)
+		}
+
+	case spec.APIKeySecretRef != nil:
+		if spec.Email == "" {
+			return nil, &CredentialsError{Err: ErrMissingCloudflareEmail}
+		}
+
+		key, err := getSecretValue(ctx, c.Client, req.Namespace, spec.APIKeySecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get api key: %w", err)
+		}
+
+		params = []string{"key", key, spec.Email}
+		create = func() (*cloudflare.API, error) {
+			return cloudflare.New(key, spec.Email, cloudflareOptions()...)
+		}
+
+	default:
+		return nil, &CredentialsError{Err: ErrMissingCloudflareToken}
+	}
+
+	api, err := c.clients.get(req.CacheKey, params, func() (interface{}, error) {
+		api, err := create()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.BaseURL != "" {
+			api.BaseURL = c.BaseURL
+		}
+
+		return api, nil
+	})
+	if err != nil {
+		return nil, &CredentialsError{Err: fmt.Errorf("failed to create cloudflare client: %w", err)}
+	}
+
+	return &cloudflareClient{
+		API:         api.(*cloudflare.API),
+		credentials: paramsHash(params),
+	}, nil
+}
+
+// cloudflareOptions disables retries of the client, which blocks the reconciler
+// while waiting. Failed requests are retried by the controller instead.
+func cloudflareOptions() []cloudflare.Option {
+	return []cloudflare.Option{
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.HTTPClient(&http.Client{
+			Transport: &cloudflareTransport{Transport: http.DefaultTransport},
+		}),
+	}
+}
+
+// Ping checks whether the Cloudflare API is reachable.
+func (c *Cloudflare) Ping(ctx context.Context) error {
+	url := c.BaseURL
+	if url == "" {
+		url = cloudflareAPIURL
+	}
+
+	return ping(ctx, url)
+}
+
+// Verify checks the API token with the token verification endpoint, or the
+// API key by getting details of the user.
+func (c *Cloudflare) Verify(ctx context.Context, req *Request) error {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if req.Target.Cloudflare.APITokenSecretRef != nil {
+		result, err := api.VerifyAPIToken()
+		if err != nil {
+			return cloudflareVerifyError(fmt.Errorf("failed to verify api token: %w", err))
+		}
+
+		if result.Status != "active" {
+			return &CredentialsError{Err: fmt.Errorf("%w: %s", ErrInactiveCloudflareToken, result.Status)}
+		}
+
+		return nil
+	}
+
+	if _, err := api.UserDetails(); err != nil {
+		return cloudflareVerifyError(fmt.Errorf("failed to verify api key: %w", err))
+	}
+
+	return nil
+}
+
+func (c *Cloudflare) sslOptions(req *Request) cloudflare.ZoneCustomSSLOptions {
+	spec := req.Target.Cloudflare
+	options := cloudflare.ZoneCustomSSLOptions{
+		Certificate:  string(req.Secret.Data[corev1.TLSCertKey]),
+		PrivateKey:   string(req.Secret.Data[corev1.TLSPrivateKeyKey]),
+		BundleMethod: spec.BundleMethod,
+		Type:         spec.Type,
+	}
+
+	if gr := spec.GeoRestrictions; gr != nil {
+		options.GeoRestrictions = &cloudflare.ZoneCustomSSLGeoRestrictions{
+			Label: gr.Label,
+		}
+	}
+
+	return options
+}
+
+func (c *Cloudflare) Create(ctx context.Context, req *Request) (*Certificate, error) {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := c.resolveZone(api, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.create(api, zone, req)
+}
+
+func (c *Cloudflare) create(api *cloudflareClient, zone *cloudflareZone, req *Request) (*Certificate, error) {
+	result, err := api.CreateSSL(zone.ID, c.sslOptions(req))
+	if err != nil {
+		return nil, cloudflareError(fmt.Errorf("failed to create certificate: %w", err))
+	}
+
+	return cloudflareCertificate(result, zone), nil
+}
+
+// Update updates the certificate. If the target is moved to another zone, a
+// new certificate is created in the new zone. The old one is left to the
+// caller, which deletes it from the zone in the status.
+func (c *Cloudflare) Update(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := c.resolveZone(api, req)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := c.existingZone(api, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if prev.ID != zone.ID {
+		return c.create(api, zone, req)
+	}
+
+	options := c.sslOptions(req)
+	options.Type = ""
+
+	result, err := api.UpdateSSL(zone.ID, id, options)
+	if err != nil {
+		return nil, cloudflareError(fmt.Errorf("failed to update certificate: %w", err))
+	}
+
+	return cloudflareCertificate(result, zone), nil
+}
+
+func (c *Cloudflare) Describe(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := c.existingZone(api, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := api.SSLDetails(zone.ID, id)
+	if err != nil {
+		if isCloudflareNotFound(err) {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		return nil, cloudflareError(fmt.Errorf("failed to get certificate: %w", err))
+	}
+
+	return cloudflareCertificate(result, zone), nil
+}
+
+func (c *Cloudflare) Delete(ctx context.Context, req *Request, id string) error {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	zone, err := c.existingZone(api, req)
+	if err != nil {
+		return err
+	}
+
+	if err := api.DeleteSSL(zone.ID, id); err != nil && !isCloudflareNotFound(err) {
+		return cloudflareError(fmt.Errorf("failed to delete certificate: %w", err))
+	}
+
+	return nil
+}
+
+// Validate checks whether the certificate covers the zone. The check is skipped
+// if the zone can't be read, e.g. the API token doesn't have the Zone:Read
+// permission.
+func (c *Cloudflare) Validate(ctx context.Context, req *Request, leaf *x509.Certificate) error {
+	api, err := c.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	zone, err := c.resolveZone(api, req)
+	if err != nil {
+		return err
+	}
+
+	name := zone.Name
+
+	if name == "" {
+		details, err := api.ZoneDetails(zone.ID)
+		if err != nil || details.Name == "" {
+			return nil
+		}
+
+		name = details.Name
+	}
+
+	for _, host := range leaf.DNSNames {
+		if inDomain(host, name) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %v is not in zone %q", ErrHostsNotCovered, leaf.DNSNames, name)
+}
+
+func cloudflareCertificate(ssl cloudflare.ZoneCustomSSL, zone *cloudflareZone) *Certificate {
+	return &Certificate{
+		ID:         ssl.ID,
+		UploadTime: ssl.UploadedOn,
+		UpdateTime: ssl.ModifiedOn,
+		ExpireTime: ssl.ExpiresOn,
+		Hosts:      ssl.Hosts,
+		ZoneID:     zone.ID,
+		ZoneName:   zone.Name,
+		State:      cloudflareState(ssl.Status),
+	}
+}
+
+func cloudflareState(status string) CertificateState {
+	switch status {
+	case "":
+		return ""
+	case "active":
+		return CertificateStateActive
+	case "pending", "initializing":
+		return CertificateStatePending
+	}
+
+	return CertificateStateFailed
+}
+
+// cloudflareStatusCode returns the HTTP status code in an error returned by
+// the Cloudflare client, or 0 if the error doesn't contain one.
+func cloudflareStatusCode(err error) int {
+	msg := err.Error()
+	i := strings.Index(msg, cloudflareStatusPrefix)
+
+	if i < 0 {
+		return 0
+	}
+
+	var code int
+
+	if _, err := fmt.Sscanf(msg[i+len(cloudflareStatusPrefix):], "%d", &code); err != nil {
+		return 0
+	}
+
+	return code
+}
+
+// cloudflareTransport returns a RetryableError instead of the response when
+// Cloudflare asks to retry later, because the Cloudflare client doesn't expose
+// headers of failed responses. Other transport errors are retryable too,
+// except canceled requests.
+type cloudflareTransport struct {
+	Transport http.RoundTripper
+}
+
+func (t *cloudflareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		return nil, &RetryableError{Err: err}
+	}
+
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return res, nil
+	}
+
+	after, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return res, nil
+	}
+
+	_ = res.Body.Close()
+
+	return nil, &RetryableError{
+		Err:        fmt.Errorf("%s%d: retry after %v", cloudflareStatusPrefix, res.StatusCode, after),
+		RetryAfter: after,
+	}
+}
+
+func isCloudflareNotFound(err error) bool {
+	return cloudflareStatusCode(err) == http.StatusNotFound
+}
+
+// cloudflareError classifies an error returned by an API call. Credentials
+// are verified before API calls, so a 403 error means the credentials don't
+// have enough permissions.
+func cloudflareError(err error) error {
+	switch code := cloudflareStatusCode(err); {
+	case IsRetryable(err):
+		return err
+	case code == http.StatusUnauthorized:
+		return &CredentialsError{Err: err}
+	case code == http.StatusForbidden:
+		return &CredentialsError{Err: err, InsufficientPermissions: true}
+	case isRetryableStatus(code):
+		return &RetryableError{Err: err}
+	}
+
+	return err
+}
+
+func cloudflareVerifyError(err error) error {
+	switch code := cloudflareStatusCode(err); {
+	case IsRetryable(err):
+		return err
+	case code == http.StatusBadRequest, code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return &CredentialsError{Err: err}
+	case isRetryableStatus(code):
+		return &RetryableError{Err: err}
+	}
+
+	return err
+}
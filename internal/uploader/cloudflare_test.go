@@ -0,0 +1,156 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudflareStatusCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{err: errors.New("HTTP status 404: not found"), expected: 404},
+		{err: fmt.Errorf("failed to get zone: %w", errors.New("HTTP status 503")), expected: 503},
+		{err: errors.New("HTTP status unknown")},
+		{err: errors.New("connection refused")},
+	}
+
+	for _, test := range tests {
+		if actual := cloudflareStatusCode(test.err); actual != test.expected {
+			t.Errorf("cloudflareStatusCode(%q) = %d, expected %d", test.err, actual, test.expected)
+		}
+	}
+}
+
+func TestCloudflareError(t *testing.T) {
+	retryable := &RetryableError{Err: errors.New("HTTP status 401")}
+
+	tests := []struct {
+		name                    string
+		err                     error
+		credentials             bool
+		insufficientPermissions bool
+		retryable               bool
+	}{
+		{
+			name:        "unauthorized",
+			err:         errors.New("HTTP status 401: invalid token"),
+			credentials: true,
+		},
+		{
+			name:                    "forbidden",
+			err:                     errors.New("HTTP status 403: forbidden"),
+			credentials:             true,
+			insufficientPermissions: true,
+		},
+		{
+			name:      "too many requests",
+			err:       errors.New("HTTP status 429: slow down"),
+			retryable: true,
+		},
+		{
+			name:      "server error",
+			err:       errors.New("HTTP status 502: bad gateway"),
+			retryable: true,
+		},
+		{
+			name:      "already retryable",
+			err:       retryable,
+			retryable: true,
+		},
+		{
+			name: "bad request",
+			err:  errors.New("HTTP status 400: invalid certificate"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			err := cloudflareError(test.err)
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v to wrap %v", err, test.err)
+			}
+
+			if actual := IsCredentialsError(err); actual != test.credentials {
+				t.Errorf("IsCredentialsError = %v, expected %v", actual, test.credentials)
+			}
+
+			var credErr *CredentialsError
+
+			if errors.As(err, &credErr) && credErr.InsufficientPermissions != test.insufficientPermissions {
+				t.Errorf("InsufficientPermissions = %v, expected %v", credErr.InsufficientPermissions, test.insufficientPermissions)
+			}
+
+			if actual := IsRetryable(err); actual != test.retryable {
+				t.Errorf("IsRetryable = %v, expected %v", actual, test.retryable)
+			}
+		})
+	}
+}
+
+func TestCloudflareTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if after := r.URL.Query().Get("retry_after"); after != "" {
+			w.Header().Set("Retry-After", after)
+		}
+
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &cloudflareTransport{Transport: http.DefaultTransport}}
+
+	t.Run("retry after", func(t *testing.T) {
+		_, err := client.Get(server.URL + "?retry_after=10")
+
+		if !IsRetryable(err) || RetryAfter(err) != 10*time.Second || cloudflareStatusCode(err) != http.StatusTooManyRequests {
+			t.Fatalf("expected a retryable error after 10s, got %v", err)
+		}
+	})
+
+	t.Run("without retry after", func(t *testing.T) {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = res.Body.Close()
+
+		if res.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("unexpected status code: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		_, err := client.Get("http://127.0.0.1:0")
+
+		if !IsRetryable(err) {
+			t.Fatalf("expected a retryable error, got %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Do(req)
+
+		if !errors.Is(err, context.Canceled) || IsRetryable(err) {
+			t.Fatalf("expected context.Canceled which isn't retryable, got %v", err)
+		}
+	})
+}
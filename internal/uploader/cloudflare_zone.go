@@ -0,0 +1,208 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// cloudflareZoneCacheTTL is how long results of zone lookups are cached.
+const cloudflareZoneCacheTTL = 10 * time.Minute
+
+var (
+	ErrMissingCloudflareZone    = errors.New("either zoneId or zoneName is required for cloudflare")
+	ErrCloudflareZoneNotFound   = errors.New("cloudflare zone not found")
+	ErrCloudflareZoneUnresolved = errors.New("cloudflare zone can't be resolved without the certificate")
+)
+
+// cloudflareZone is a Cloudflare zone. Name is empty if it is unknown.
+type cloudflareZone struct {
+	ID   string
+	Name string
+}
+
+// zoneCache caches zone IDs by credentials and zone names. Zones which don't
+// exist are cached as empty IDs. The zero value is ready to use.
+type zoneCache struct {
+	mu      sync.Mutex
+	entries map[string]zoneCacheEntry
+}
+
+type zoneCacheEntry struct {
+	id      string
+	expires time.Time
+}
+
+func (z *zoneCache) get(key string) (string, bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	entry, ok := z.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+
+	return entry.id, true
+}
+
+func (z *zoneCache) set(key, id string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.entries == nil {
+		z.entries = map[string]zoneCacheEntry{}
+	}
+
+	z.entries[key] = zoneCacheEntry{
+		id:      id,
+		expires: time.Now().Add(cloudflareZoneCacheTTL),
+	}
+}
+
+// resolveZone returns the zone which certificates of the target are uploaded
+// to.
+func (c *Cloudflare) resolveZone(api *cloudflareClient, req *Request) (*cloudflareZone, error) {
+	spec := req.Target.Cloudflare
+
+	switch {
+	case spec.ZoneID != "":
+		return &cloudflareZone{ID: spec.ZoneID}, nil
+	case spec.ZoneName == v1alpha1.CloudflareZoneAuto:
+		return c.autoZone(api, req)
+	case spec.ZoneName != "":
+		name := normalizeDomain(spec.ZoneName)
+
+		id, err := c.lookupZone(api, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == "" {
+			return nil, fmt.Errorf("%w: %s", ErrCloudflareZoneNotFound, name)
+		}
+
+		return &cloudflareZone{ID: id, Name: name}, nil
+	}
+
+	return nil, ErrMissingCloudflareZone
+}
+
+// existingZone returns the zone which the certificate of the target was
+// uploaded to.
+func (c *Cloudflare) existingZone(api *cloudflareClient, req *Request) (*cloudflareZone, error) {
+	if s := req.Status; s != nil && s.ZoneID != "" {
+		return &cloudflareZone{ID: s.ZoneID, Name: s.ZoneName}, nil
+	}
+
+	return c.resolveZone(api, req)
+}
+
+// autoZone returns the most specific zone containing a host of the
+// certificate. Every host is resolved, and zones with more labels are
+// preferred, then the first name in lexical order, so the zone doesn't depend
+// on the order of hosts.
+func (c *Cloudflare) autoZone(api *cloudflareClient, req *Request) (*cloudflareZone, error) {
+	if req.Secret == nil {
+		return nil, ErrCloudflareZoneUnresolved
+	}
+
+	leaf, err := parseLeafCertificate(req.Secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, err
+	}
+
+	var result *cloudflareZone
+
+	for _, host := range leaf.DNSNames {
+		for _, name := range parentDomains(host) {
+			id, err := c.lookupZone(api, name)
+			if err != nil {
+				return nil, err
+			}
+
+			if id == "" {
+				continue
+			}
+
+			if result == nil || moreSpecificZone(name, result.Name) {
+				result = &cloudflareZone{ID: id, Name: name}
+			}
+
+			break
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("%w: no zone contains %v", ErrCloudflareZoneNotFound, leaf.DNSNames)
+	}
+
+	return result, nil
+}
+
+// moreSpecificZone returns true if zone a has more labels than zone b, or the
+// same number of labels and a lower name.
+func moreSpecificZone(a, b string) bool {
+	if la, lb := strings.Count(a, "."), strings.Count(b, "."); la != lb {
+		return la > lb
+	}
+
+	return a < b
+}
+
+// lookupZone returns the ID of the zone, or an empty string if the zone does
+// not exist.
+func (c *Cloudflare) lookupZone(api *cloudflareClient, name string) (string, error) {
+	key := api.credentials + "/" + name
+
+	if id, ok := c.zones.get(key); ok {
+		return id, nil
+	}
+
+	zones, err := api.ListZones(name)
+	if err != nil {
+		return "", cloudflareError(fmt.Errorf("failed to list zones: %w", err))
+	}
+
+	var id string
+
+	for _, zone := range zones {
+		if normalizeDomain(zone.Name) == name {
+			id = zone.ID
+
+			break
+		}
+	}
+
+	c.zones.set(key, id)
+
+	return id, nil
+}
+
+// parentDomains returns the host and its parent domains, from the most
+// specific one, excluding the top-level domain.
+func parentDomains(host string) []string {
+	labels := strings.Split(normalizeDomain(strings.TrimPrefix(host, "*.")), ".")
+	domains := make([]string, 0, len(labels))
+
+	for i := 0; i < len(labels)-1; i++ {
+		domains = append(domains, strings.Join(labels[i:], "."))
+	}
+
+	return domains
+}
+
+func normalizeDomain(s string) string {
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
+
+func inDomain(host, domain string) bool {
+	host = normalizeDomain(host)
+	domain = normalizeDomain(domain)
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
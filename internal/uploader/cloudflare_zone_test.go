@@ -0,0 +1,389 @@
+package uploader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader/cloudflaretest"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testShopZoneID  = "shop-zone"
+	testOtherZoneID = "other-zone"
+)
+
+func newTestCloudflareServer() *cloudflaretest.Server {
+	api := cloudflaretest.NewServer()
+	api.AddZone(testShopZoneID, "shop.example.com")
+	api.AddZone(testOtherZoneID, "example.net")
+
+	return api
+}
+
+func newTestCloudflare(api *cloudflaretest.Server) *Cloudflare {
+	return &Cloudflare{
+		Client: fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cloudflare"},
+			Data: map[string][]byte{
+				"token": []byte(cloudflaretest.APIToken),
+			},
+		}).Build(),
+		BaseURL: api.URL(),
+	}
+}
+
+func newCloudflareRequest(t *testing.T, spec v1alpha1.CloudflareUploadSpec, hosts ...string) *Request {
+	t.Helper()
+
+	spec.APITokenSecretRef = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "cloudflare"},
+		Key:                  "token",
+	}
+
+	req := &Request{
+		Namespace: "default",
+		Target:    &v1alpha1.UploadTarget{Name: "cloudflare", Cloudflare: &spec},
+	}
+
+	if len(hosts) > 0 {
+		req.Secret = newTestTLSSecret(t, hosts...)
+	}
+
+	return req
+}
+
+// newTestTLSSecret returns a secret with a self-signed certificate for the
+// hosts.
+func newTestTLSSecret(t *testing.T, hosts ...string) *corev1.Secret {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &corev1.Secret{
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func TestCloudflareResolveZone(t *testing.T) {
+	ctx := context.Background()
+	api := newTestCloudflareServer()
+	defer api.Close()
+
+	tests := []struct {
+		name     string
+		spec     v1alpha1.CloudflareUploadSpec
+		hosts    []string
+		expected *cloudflareZone
+		err      error
+	}{
+		{
+			name:     "zone id",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneID: "any-zone"},
+			expected: &cloudflareZone{ID: "any-zone"},
+		},
+		{
+			name:     "zone name",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: "Example.COM."},
+			expected: &cloudflareZone{ID: cloudflaretest.ZoneID, Name: cloudflaretest.ZoneName},
+		},
+		{
+			name: "zone name not found",
+			spec: v1alpha1.CloudflareUploadSpec{ZoneName: "example.org"},
+			err:  ErrCloudflareZoneNotFound,
+		},
+		{
+			name:     "auto",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts:    []string{"www.example.com"},
+			expected: &cloudflareZone{ID: cloudflaretest.ZoneID, Name: cloudflaretest.ZoneName},
+		},
+		{
+			name:     "auto most specific zone",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts:    []string{"*.www.shop.example.com"},
+			expected: &cloudflareZone{ID: testShopZoneID, Name: "shop.example.com"},
+		},
+		{
+			name:     "auto second host",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts:    []string{"www.example.org", "www.example.net"},
+			expected: &cloudflareZone{ID: testOtherZoneID, Name: "example.net"},
+		},
+		{
+			name:     "auto most specific zone of any host",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts:    []string{"www.example.com", "www.shop.example.com"},
+			expected: &cloudflareZone{ID: testShopZoneID, Name: "shop.example.com"},
+		},
+		{
+			name:     "auto zones with the same labels",
+			spec:     v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts:    []string{"www.example.net", "www.example.com"},
+			expected: &cloudflareZone{ID: cloudflaretest.ZoneID, Name: cloudflaretest.ZoneName},
+		},
+		{
+			name:  "auto not found",
+			spec:  v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			hosts: []string{"www.example.org"},
+			err:   ErrCloudflareZoneNotFound,
+		},
+		{
+			name: "auto without secret",
+			spec: v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto},
+			err:  ErrCloudflareZoneUnresolved,
+		},
+		{
+			name: "missing zone",
+			err:  ErrMissingCloudflareZone,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCloudflare(api)
+			req := newCloudflareRequest(t, test.spec, test.hosts...)
+
+			client, err := c.newClient(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zone, err := c.resolveZone(client, req)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *zone != *test.expected {
+				t.Fatalf("expected %+v, got %+v", test.expected, zone)
+			}
+		})
+	}
+}
+
+func TestCloudflareAutoZoneHostOrder(t *testing.T) {
+	ctx := context.Background()
+	api := newTestCloudflareServer()
+	defer api.Close()
+
+	c := newTestCloudflare(api)
+	hosts := []string{"www.example.com", "www.shop.example.com", "www.example.net"}
+
+	// SANs may be reordered when the certificate is renewed
+	for i := range hosts {
+		ordered := append(append([]string{}, hosts[i:]...), hosts[:i]...)
+		req := newCloudflareRequest(t, v1alpha1.CloudflareUploadSpec{ZoneName: v1alpha1.CloudflareZoneAuto}, ordered...)
+
+		client, err := c.newClient(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zone, err := c.resolveZone(client, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if zone.ID != testShopZoneID {
+			t.Fatalf("expected zone %s for hosts %v, got %+v", testShopZoneID, ordered, zone)
+		}
+	}
+}
+
+func TestCloudflareZoneCache(t *testing.T) {
+	ctx := context.Background()
+	api := newTestCloudflareServer()
+	defer api.Close()
+
+	c := newTestCloudflare(api)
+
+	resolve := func(name string) error {
+		req := newCloudflareRequest(t, v1alpha1.CloudflareUploadSpec{ZoneName: name})
+
+		client, err := c.newClient(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.resolveZone(client, req)
+
+		return err
+	}
+
+	expectLookups := func(expected int) {
+		t.Helper()
+
+		if actual := api.ZoneLookups(); actual != expected {
+			t.Fatalf("expected %d zone lookups, got %d", expected, actual)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := resolve(cloudflaretest.ZoneName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectLookups(1)
+
+	// Zones which don't exist are cached too
+	for i := 0; i < 2; i++ {
+		if err := resolve("example.org"); !errors.Is(err, ErrCloudflareZoneNotFound) {
+			t.Fatalf("expected ErrCloudflareZoneNotFound, got %v", err)
+		}
+	}
+
+	expectLookups(2)
+
+	// Zones are looked up again after the cache is expired
+	c.zones.mu.Lock()
+	for key, entry := range c.zones.entries {
+		entry.expires = time.Now().Add(-time.Second)
+		c.zones.entries[key] = entry
+	}
+	c.zones.mu.Unlock()
+
+	if err := resolve(cloudflaretest.ZoneName); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLookups(3)
+}
+
+func TestCloudflareZoneMove(t *testing.T) {
+	ctx := context.Background()
+	api := newTestCloudflareServer()
+	defer api.Close()
+
+	c := newTestCloudflare(api)
+	req := newCloudflareRequest(t, v1alpha1.CloudflareUploadSpec{ZoneName: cloudflaretest.ZoneName}, "www.example.com")
+
+	prev, err := c.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prev.ZoneID != cloudflaretest.ZoneID || prev.ZoneName != cloudflaretest.ZoneName {
+		t.Fatalf("unexpected certificate: %+v", prev)
+	}
+
+	prevStatus := &v1alpha1.UploadTargetStatus{
+		Name:          "cloudflare",
+		CertificateID: prev.ID,
+		ZoneID:        prev.ZoneID,
+		ZoneName:      prev.ZoneName,
+	}
+
+	// The target is moved to another zone
+	req.Target.Cloudflare.ZoneName = "example.net"
+	req.Status = prevStatus
+
+	cert, err := c.Update(ctx, req, prev.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.ID == prev.ID || cert.ZoneID != testOtherZoneID || cert.ZoneName != "example.net" {
+		t.Fatalf("expected a certificate created in the new zone, got %+v", cert)
+	}
+
+	if stored := api.Certificate(cert.ID); stored == nil || stored.ZoneCustomSSL.ZoneID != testOtherZoneID {
+		t.Fatalf("unexpected certificate in the new zone: %+v", stored)
+	}
+
+	// The certificate in the previous zone is left to the caller
+	if api.Certificate(prev.ID) == nil || api.Updates(prev.ID) != 0 {
+		t.Fatalf("certificate %s in the previous zone is changed", prev.ID)
+	}
+
+	t.Run("update in new zone", func(t *testing.T) {
+		req.Status = &v1alpha1.UploadTargetStatus{Name: "cloudflare", CertificateID: cert.ID, ZoneID: cert.ZoneID}
+
+		updated, err := c.Update(ctx, req, cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.ID != cert.ID || api.Updates(cert.ID) != 1 {
+			t.Fatalf("expected certificate %s to be updated, got %+v", cert.ID, updated)
+		}
+	})
+
+	t.Run("delete from previous zone", func(t *testing.T) {
+		prevReq := newCloudflareRequest(t, v1alpha1.CloudflareUploadSpec{ZoneName: "example.net"})
+		prevReq.Status = prevStatus
+
+		api.FailDeletes(cloudflaretest.ZoneID, true)
+
+		if err := c.Delete(ctx, prevReq, prev.ID); !IsRetryable(err) {
+			t.Fatalf("expected a retryable error, got %v", err)
+		}
+
+		if api.Certificate(prev.ID) == nil {
+			t.Fatalf("certificate %s is deleted", prev.ID)
+		}
+
+		api.FailDeletes(cloudflaretest.ZoneID, false)
+
+		if err := c.Delete(ctx, prevReq, prev.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if api.Certificate(prev.ID) != nil {
+			t.Fatalf("certificate %s is not deleted", prev.ID)
+		}
+
+		if api.Certificate(cert.ID) == nil {
+			t.Fatalf("certificate %s in the new zone is deleted", cert.ID)
+		}
+	})
+}
@@ -0,0 +1,300 @@
+// Package cloudflaretest provides a fake Cloudflare API for tests.
+package cloudflaretest
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	// ZoneID and ZoneName are the zone which exists on a new server.
+	ZoneID   = "test-zone"
+	ZoneName = "example.com"
+
+	// APIToken is the only API token accepted by the server.
+	APIToken = "valid-token"
+)
+
+// Server is a Cloudflare API server storing zones and custom certificates in
+// memory.
+type Server struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	nextID       int
+	zones        map[string]string
+	certificates map[string]*Certificate
+	updates      map[string]int
+	zoneLookups  int
+	failDeletes  map[string]bool
+}
+
+// Certificate is a custom certificate stored in the server.
+type Certificate struct {
+	ZoneCustomSSL cloudflare.ZoneCustomSSL
+	Certificate   string
+	PrivateKey    string
+}
+
+// NewServer starts a server with the zone in ZoneID and ZoneName.
+func NewServer() *Server {
+	s := &Server{
+		zones:        map[string]string{ZoneID: ZoneName},
+		certificates: map[string]*Certificate{},
+		updates:      map[string]int{},
+		failDeletes:  map[string]bool{},
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// AddZone adds a zone to the server.
+func (s *Server) AddZone(id, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.zones[id] = name
+}
+
+// Certificate returns the custom certificate with the ID, or nil if it does
+// not exist.
+func (s *Server) Certificate(id string) *Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cert, ok := s.certificates[id]; ok {
+		copied := *cert
+
+		return &copied
+	}
+
+	return nil
+}
+
+// Updates returns how many times the custom certificate was updated.
+func (s *Server) Updates(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updates[id]
+}
+
+// ZoneLookups returns how many times zones were listed by name.
+func (s *Server) ZoneLookups() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.zoneLookups
+}
+
+// FailDeletes makes deleting custom certificates of the zone fail with a
+// server error until it is called again with false.
+func (s *Server) FailDeletes(zoneID string, fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failDeletes[zoneID] = fail
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+APIToken {
+		writeError(w, http.StatusUnauthorized, "Invalid API Token")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/user/tokens/verify":
+		writeResult(w, cloudflare.APITokenVerifyBody{ID: "token", Status: "active"})
+
+	case r.URL.Path == "/zones":
+		s.listZones(w, r)
+
+	case len(path) == 2 && path[0] == "zones":
+		name, ok := s.zones[path[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Zone not found")
+
+			return
+		}
+
+		writeResult(w, cloudflare.Zone{ID: path[1], Name: name})
+
+	case len(path) >= 3 && path[0] == "zones" && path[2] == "custom_certificates":
+		if _, ok := s.zones[path[1]]; !ok {
+			writeError(w, http.StatusNotFound, "Zone not found")
+
+			return
+		}
+
+		if len(path) == 3 && r.Method == http.MethodPost {
+			s.createCertificate(w, r, path[1])
+
+			return
+		}
+
+		if len(path) == 4 {
+			s.handleCertificate(w, r, path[1], path[3])
+
+			return
+		}
+
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	zones := []cloudflare.Zone{}
+
+	s.zoneLookups++
+
+	for id, zoneName := range s.zones {
+		if name == "" || name == zoneName {
+			zones = append(zones, cloudflare.Zone{ID: id, Name: zoneName})
+		}
+	}
+
+	writeResult(w, zones)
+}
+
+func (s *Server) createCertificate(w http.ResponseWriter, r *http.Request, zoneID string) {
+	var options cloudflare.ZoneCustomSSLOptions
+
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.nextID++
+	now := time.Now()
+	cert := &Certificate{
+		ZoneCustomSSL: cloudflare.ZoneCustomSSL{
+			ID:           fmt.Sprintf("cert-%d", s.nextID),
+			ZoneID:       zoneID,
+			Status:       "active",
+			BundleMethod: options.BundleMethod,
+			UploadedOn:   now,
+		},
+	}
+
+	if err := cert.apply(&options, now); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.certificates[cert.ZoneCustomSSL.ID] = cert
+	writeResult(w, cert.ZoneCustomSSL)
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request, zoneID, id string) {
+	cert, ok := s.certificates[id]
+	if !ok || cert.ZoneCustomSSL.ZoneID != zoneID {
+		writeError(w, http.StatusNotFound, "Certificate not found")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeResult(w, cert.ZoneCustomSSL)
+
+	case http.MethodPatch:
+		var options cloudflare.ZoneCustomSSLOptions
+
+		if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		if err := cert.apply(&options, time.Now()); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		s.updates[id]++
+		writeResult(w, cert.ZoneCustomSSL)
+
+	case http.MethodDelete:
+		if s.failDeletes[zoneID] {
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+
+			return
+		}
+
+		delete(s.certificates, id)
+		writeResult(w, map[string]string{"id": id})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (c *Certificate) apply(options *cloudflare.ZoneCustomSSLOptions, now time.Time) error {
+	block, _ := pem.Decode([]byte(options.Certificate))
+	if block == nil {
+		return fmt.Errorf("invalid certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	c.Certificate = options.Certificate
+	c.PrivateKey = options.PrivateKey
+	c.ZoneCustomSSL.Hosts = leaf.DNSNames
+	c.ZoneCustomSSL.ExpiresOn = leaf.NotAfter
+	c.ZoneCustomSSL.ModifiedOn = now
+
+	return nil
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"errors":   []interface{}{},
+		"messages": []interface{}{},
+		"result":   result,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(cloudflare.Response{
+		Errors: []cloudflare.ResponseInfo{{Code: status, Message: message}},
+	})
+}
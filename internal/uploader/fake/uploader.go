@@ -0,0 +1,177 @@
+// Package fake provides an in-memory uploader for tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+)
+
+// Uploader stores certificates in memory and records calls.
+type Uploader struct {
+	// ConfiguredFunc returns true if the target has settings for the
+	// uploader. All targets are configured if it is nil.
+	ConfiguredFunc func(target *v1alpha1.UploadTarget) bool
+
+	// Err is returned by Create, Update, Describe and Delete if it is not nil.
+	Err error
+
+	// ZoneID is the zone of created certificates. Certificates are created
+	// again by Update when it is changed, like Cloudflare certificates moved
+	// to another zone.
+	ZoneID string
+
+	// Immutable makes Update create a new certificate, like Compute Engine
+	// SSL certificates.
+	Immutable bool
+
+	mu           sync.Mutex
+	certificates map[string]*uploader.Certificate
+	calls        []string
+	lastID       int
+}
+
+func (u *Uploader) Name() string {
+	return "Fake"
+}
+
+func (u *Uploader) Configured(target *v1alpha1.UploadTarget) bool {
+	if u.ConfiguredFunc == nil {
+		return true
+	}
+
+	return u.ConfiguredFunc(target)
+}
+
+func (u *Uploader) Create(ctx context.Context, req *uploader.Request) (*uploader.Certificate, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.calls = append(u.calls, "Create")
+
+	if u.Err != nil {
+		return nil, u.Err
+	}
+
+	return u.create(), nil
+}
+
+func (u *Uploader) create() *uploader.Certificate {
+	u.lastID++
+	now := time.Now()
+	cert := &uploader.Certificate{
+		ID:         fmt.Sprintf("cert-%d", u.lastID),
+		UploadTime: now,
+		UpdateTime: now,
+		ZoneID:     u.ZoneID,
+	}
+
+	if u.certificates == nil {
+		u.certificates = map[string]*uploader.Certificate{}
+	}
+
+	u.certificates[cert.ID] = cert
+
+	return copyCertificate(cert)
+}
+
+func (u *Uploader) Update(ctx context.Context, req *uploader.Request, id string) (*uploader.Certificate, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.calls = append(u.calls, "Update "+id)
+
+	if u.Err != nil {
+		return nil, u.Err
+	}
+
+	cert, ok := u.certificates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", uploader.ErrNotFound, id)
+	}
+
+	if u.Immutable || cert.ZoneID != u.ZoneID {
+		return u.create(), nil
+	}
+
+	cert.UpdateTime = time.Now()
+
+	return copyCertificate(cert), nil
+}
+
+func (u *Uploader) Describe(ctx context.Context, req *uploader.Request, id string) (*uploader.Certificate, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.calls = append(u.calls, "Describe "+id)
+
+	if u.Err != nil {
+		return nil, u.Err
+	}
+
+	cert, ok := u.certificates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", uploader.ErrNotFound, id)
+	}
+
+	return copyCertificate(cert), nil
+}
+
+func (u *Uploader) Delete(ctx context.Context, req *uploader.Request, id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.calls = append(u.calls, "Delete "+id)
+
+	if u.Err != nil {
+		return u.Err
+	}
+
+	delete(u.certificates, id)
+
+	return nil
+}
+
+// Calls returns calls of Create, Update, Describe and Delete in order, e.g.
+// "Create" and "Update cert-1".
+func (u *Uploader) Calls() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return append([]string(nil), u.calls...)
+}
+
+// Certificate returns the stored certificate, or nil if it does not exist.
+func (u *Uploader) Certificate(id string) *uploader.Certificate {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if cert, ok := u.certificates[id]; ok {
+		return copyCertificate(cert)
+	}
+
+	return nil
+}
+
+// Put stores a copy of the certificate, replacing the one with the same ID,
+// e.g. to simulate changes on the provider.
+func (u *Uploader) Put(cert *uploader.Certificate) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.certificates == nil {
+		u.certificates = map[string]*uploader.Certificate{}
+	}
+
+	u.certificates[cert.ID] = copyCertificate(cert)
+}
+
+func copyCertificate(cert *uploader.Certificate) *uploader.Certificate {
+	c := *cert
+
+	return &c
+}
@@ -0,0 +1,458 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	ErrMissingGCPProject = errors.New("project is required")
+	ErrInvalidGCPType    = errors.New("type must be either CertificateManager or Compute")
+)
+
+const (
+	gcpCertificateManagerURL = "https://certificatemanager.googleapis.com/v1/"
+	gcpComputeURL            = "https://compute.googleapis.com/compute/v1/"
+
+	// gcpNamePrefix is the prefix of generated names.
+	gcpNamePrefix = "cert-uploader-"
+
+	// gcpOperationTimeout is how long to wait for a long-running operation.
+	// The operation is retried in the next reconciliation if it's not done.
+	gcpOperationTimeout = 2 * time.Minute
+)
+
+// GCP uploads certificates to Google Cloud, either as self-managed
+// certificates of Certificate Manager or as Compute Engine SSL certificates.
+type GCP struct {
+	Client client.Client
+
+	// CertificateManagerURL and ComputeURL override the default URLs of the
+	// APIs. They are used in tests. Endpoints of targets take precedence.
+	CertificateManagerURL string
+	ComputeURL            string
+
+	clients clientCache
+
+	mu        sync.Mutex
+	endpoints map[string]bool
+}
+
+func (g *GCP) Name() string {
+	return "Google Cloud"
+}
+
+func (g *GCP) Configured(target *v1alpha1.UploadTarget) bool {
+	return target.GCP != nil
+}
+
+// gcpClient sends requests to a REST API of Google Cloud.
+type gcpClient struct {
+	http     *http.Client
+	tokens   oauth2.TokenSource
+	endpoint string
+}
+
+func (g *GCP) newClient(ctx context.Context, req *Request) (*gcpClient, error) {
+	spec := req.Target.GCP
+
+	if spec.Project == "" {
+		return nil, ErrMissingGCPProject
+	}
+
+	endpoint := spec.Endpoint
+
+	if endpoint == "" {
+		switch gcpType(spec) {
+		case v1alpha1.GCPTypeCertificateManager:
+			endpoint = g.certificateManagerURL()
+		case v1alpha1.GCPTypeCompute:
+			endpoint = g.computeURL()
+		default:
+			return nil, ErrInvalidGCPType
+		}
+	}
+
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+
+	g.addEndpoint(endpoint)
+
+	var key string
+
+	params := []string{endpoint}
+
+	if spec.ServiceAccountKeySecretRef != nil {
+		var err error
+
+		if key, err = getSecretValue(ctx, g.Client, req.Namespace, spec.ServiceAccountKeySecretRef); err != nil {
+			return nil, fmt.Errorf("failed to get service account key: %w", err)
+		}
+
+		params = append(params, key)
+	}
+
+	c, err := g.clients.get(req.CacheKey, params, func() (interface{}, error) {
+		tokens, err := gcpTokenSource(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return &gcpClient{
+			http: &http.Client{
+				Transport: &oauth2.Transport{
+					Source: tokens,
+					Base:   http.DefaultTransport,
+				},
+			},
+			tokens:   tokens,
+			endpoint: endpoint,
+		}, nil
+	})
+	if err != nil {
+		return nil, &CredentialsError{Err: err}
+	}
+
+	return c.(*gcpClient), nil
+}
+
+// Verify checks whether an access token can be obtained with the service
+// account key or the workload identity.
+func (g *GCP) Verify(ctx context.Context, req *Request) error {
+	c, err := g.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.tokens.Token(); err != nil {
+		return &CredentialsError{Err: fmt.Errorf("failed to get access token: %w", err)}
+	}
+
+	return nil
+}
+
+// Ping checks whether endpoints used by targets are reachable. The default
+// Certificate Manager and Compute Engine endpoints are checked before any
+// target is uploaded.
+func (g *GCP) Ping(ctx context.Context) error {
+	for _, u := range g.usedEndpoints() {
+		if err := ping(ctx, u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *GCP) certificateManagerURL() string {
+	if g.CertificateManagerURL != "" {
+		return g.CertificateManagerURL
+	}
+
+	return gcpCertificateManagerURL
+}
+
+func (g *GCP) computeURL() string {
+	if g.ComputeURL != "" {
+		return g.ComputeURL
+	}
+
+	return gcpComputeURL
+}
+
+func (g *GCP) addEndpoint(endpoint string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.endpoints == nil {
+		g.endpoints = map[string]bool{}
+	}
+
+	g.endpoints[endpoint] = true
+}
+
+func (g *GCP) usedEndpoints() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.endpoints) == 0 {
+		return []string{g.certificateManagerURL(), g.computeURL()}
+	}
+
+	endpoints := make([]string, 0, len(g.endpoints))
+
+	for u := range g.endpoints {
+		endpoints = append(endpoints, u)
+	}
+
+	sort.Strings(endpoints)
+
+	return endpoints
+}
+
+func (g *GCP) Create(ctx context.Context, req *Request) (*Certificate, error) {
+	c, err := g.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Target.GCP.Name
+	if name == "" {
+		if name, err = gcpGenerateName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if gcpType(req.Target.GCP) == v1alpha1.GCPTypeCompute {
+		return c.insertSSLCertificate(ctx, req, computeParent(req.Target.GCP), name)
+	}
+
+	return c.createManagedCertificate(ctx, req, name)
+}
+
+// Update updates the certificate. Compute SSL certificates are immutable, so
+// a new version is created. Certificate Manager certificates are created again
+// if the name or the location is changed. Replaced certificates are left to
+// the caller, which deletes them like after a rollover.
+func (g *GCP) Update(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	c, err := g.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := req.Target.GCP
+
+	if gcpType(spec) == v1alpha1.GCPTypeCompute {
+		return c.insertSSLCertificate(ctx, req, computeParent(spec), gcpName(spec, computeNamePrefix(path.Base(id))))
+	}
+
+	if name := gcpName(spec, path.Base(id)); managedCertificateID(spec, name) != id {
+		return c.createManagedCertificate(ctx, req, name)
+	}
+
+	return c.updateManagedCertificate(ctx, req, id)
+}
+
+func (g *GCP) Describe(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	c, err := g.newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcpType(req.Target.GCP) == v1alpha1.GCPTypeCompute {
+		return c.describeSSLCertificate(ctx, id)
+	}
+
+	return c.describeManagedCertificate(ctx, id)
+}
+
+func (g *GCP) Delete(ctx context.Context, req *Request, id string) error {
+	c, err := g.newClient(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if gcpType(req.Target.GCP) == v1alpha1.GCPTypeCompute {
+		return c.deleteManagedSSLCertificate(ctx, id, req.Owner)
+	}
+
+	return c.deleteManagedCertificate(ctx, id)
+}
+
+func gcpType(spec *v1alpha1.GCPUploadSpec) string {
+	if spec.Type == "" {
+		return v1alpha1.GCPTypeCertificateManager
+	}
+
+	return spec.Type
+}
+
+func gcpLocation(spec *v1alpha1.GCPUploadSpec) string {
+	if spec.Location == "" {
+		return v1alpha1.GCPLocationGlobal
+	}
+
+	return spec.Location
+}
+
+// gcpName returns the name in the spec, or the name of the existing
+// certificate if the name was generated.
+func gcpName(spec *v1alpha1.GCPUploadSpec, existing string) string {
+	if spec.Name != "" {
+		return spec.Name
+	}
+
+	return existing
+}
+
+// gcpGenerateName returns a random name, which starts with a letter and
+// contains only lowercase letters, digits and hyphens as required by both
+// APIs.
+func gcpGenerateName() (string, error) {
+	buf := make([]byte, 4)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate name: %w", err)
+	}
+
+	return gcpNamePrefix + hex.EncodeToString(buf), nil
+}
+
+// do sends a JSON request to the path relative to the endpoint, and decodes
+// the response into out if it's not nil.
+func (c *gcpClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.endpoint + path
+
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+
+		// The token endpoint rejected the credentials
+		if errors.As(err, &retrieveErr) {
+			return &CredentialsError{Err: err}
+		}
+
+		return &RetryableError{Err: err}
+	}
+
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &RetryableError{Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return gcpResponseError(res, data)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// gcpAPIError is an error returned by a Google Cloud API. Reason is only
+// returned by the Compute Engine API.
+type gcpAPIError struct {
+	StatusCode int
+	Message    string
+	Reason     string
+}
+
+func (e *gcpAPIError) Error() string {
+	return fmt.Sprintf("HTTP status %d: %s", e.StatusCode, e.Message)
+}
+
+func gcpResponseError(res *http.Response, data []byte) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+
+	_ = json.Unmarshal(data, &body)
+
+	apiErr := &gcpAPIError{
+		StatusCode: res.StatusCode,
+		Message:    body.Error.Message,
+	}
+
+	if len(body.Error.Errors) > 0 {
+		apiErr.Reason = body.Error.Errors[0].Reason
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(res.StatusCode)
+	}
+
+	switch code := res.StatusCode; {
+	case code == http.StatusUnauthorized:
+		return &CredentialsError{Err: apiErr}
+	case code == http.StatusForbidden:
+		return &CredentialsError{Err: apiErr, InsufficientPermissions: true}
+	case isRetryableStatus(code):
+		after, _ := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+
+		return &RetryableError{Err: apiErr, RetryAfter: after}
+	}
+
+	return apiErr
+}
+
+func gcpStatusCode(err error) int {
+	var apiErr *gcpAPIError
+
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+
+	return 0
+}
+
+func gcpErrorReason(err error) string {
+	var apiErr *gcpAPIError
+
+	if errors.As(err, &apiErr) {
+		return apiErr.Reason
+	}
+
+	return ""
+}
+
+func parseGCPTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+
+	return t
+}
@@ -0,0 +1,120 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+var ErrInvalidGCPServiceAccountKey = errors.New("invalid service account key")
+
+const (
+	gcpScope       = "https://www.googleapis.com/auth/cloud-platform"
+	gcpTokenURL    = "https://oauth2.googleapis.com/token"
+	gcpMetadataURL = "http://metadata.google.internal"
+
+	gcpMetadataTimeout = 10 * time.Second
+)
+
+// gcpServiceAccountKey is a JSON key of a Google Cloud service account.
+type gcpServiceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// gcpTokenSource returns a token source of the service account key, or of
+// the workload identity from the metadata server if the key is empty.
+func gcpTokenSource(ctx context.Context, key string) (oauth2.TokenSource, error) {
+	if key == "" {
+		return oauth2.ReuseTokenSource(nil, &gcpMetadataTokenSource{
+			client: &http.Client{Timeout: gcpMetadataTimeout},
+		}), nil
+	}
+
+	var sa gcpServiceAccountKey
+
+	if err := json.Unmarshal([]byte(key), &sa); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGCPServiceAccountKey, err)
+	}
+
+	if sa.Type != "service_account" || sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, fmt.Errorf("%w: type must be service_account, and client_email and private_key are required", ErrInvalidGCPServiceAccountKey)
+	}
+
+	config := &jwt.Config{
+		Email:        sa.ClientEmail,
+		PrivateKey:   []byte(sa.PrivateKey),
+		PrivateKeyID: sa.PrivateKeyID,
+		Scopes:       []string{gcpScope},
+		TokenURL:     sa.TokenURI,
+	}
+
+	if config.TokenURL == "" {
+		config.TokenURL = gcpTokenURL
+	}
+
+	// The token source is cached with the client. The context only provides
+	// the HTTP client fetching tokens, so it can outlive the request.
+	return config.TokenSource(ctx), nil
+}
+
+// gcpMetadataTokenSource gets tokens of the default service account from the
+// metadata server, which is the workload identity on GKE. The host of the
+// server can be changed with GCE_METADATA_HOST.
+type gcpMetadataTokenSource struct {
+	client *http.Client
+}
+
+func (s *gcpMetadataTokenSource) Token() (*oauth2.Token, error) {
+	base := gcpMetadataURL
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		base = "http://" + host
+	}
+
+	u := base + "/computeMetadata/v1/instance/service-accounts/default/token?" + url.Values{"scopes": {gcpScope}}.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token from metadata server: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get token from metadata server: HTTP status %d", res.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: body.AccessToken,
+		TokenType:   body.TokenType,
+		Expiry:      time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
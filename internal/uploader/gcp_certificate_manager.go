@@ -0,0 +1,229 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// gcpManagedLabel marks Certificate Manager certificates created by the
+	// controller.
+	gcpManagedLabel      = "managed-by"
+	gcpManagedLabelValue = "cert-uploader"
+
+	gcpOperationInterval = time.Second
+)
+
+// gRPC codes of failed operations which may succeed later.
+// nolint: gochecknoglobals
+var gcpRetryableOperationCodes = map[int]bool{
+	4:  true, // DEADLINE_EXCEEDED
+	8:  true, // RESOURCE_EXHAUSTED
+	10: true, // ABORTED
+	14: true, // UNAVAILABLE
+}
+
+type gcpManagedCertificate struct {
+	Name        string                     `json:"name,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Labels      map[string]string          `json:"labels,omitempty"`
+	SelfManaged *gcpSelfManagedCertificate `json:"selfManaged,omitempty"`
+	SANDNSNames []string                   `json:"sanDnsnames,omitempty"`
+	CreateTime  string                     `json:"createTime,omitempty"`
+	UpdateTime  string                     `json:"updateTime,omitempty"`
+	ExpireTime  string                     `json:"expireTime,omitempty"`
+}
+
+type gcpSelfManagedCertificate struct {
+	PEMCertificate string `json:"pemCertificate"`
+	PEMPrivateKey  string `json:"pemPrivateKey"`
+}
+
+type gcpManagedOperation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func managedCertificateBody(req *Request) *gcpManagedCertificate {
+	spec := req.Target.GCP
+	labels := map[string]string{}
+
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+
+	labels[gcpManagedLabel] = gcpManagedLabelValue
+
+	return &gcpManagedCertificate{
+		Description: spec.Description,
+		Labels:      labels,
+		SelfManaged: &gcpSelfManagedCertificate{
+			PEMCertificate: string(req.Secret.Data[corev1.TLSCertKey]),
+			PEMPrivateKey:  string(req.Secret.Data[corev1.TLSPrivateKeyKey]),
+		},
+	}
+}
+
+func managedCertificateID(spec *v1alpha1.GCPUploadSpec, name string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/certificates/%s", spec.Project, gcpLocation(spec), name)
+}
+
+// createManagedCertificate creates a self-managed certificate. If the
+// certificate already exists and was created by the controller, e.g. the
+// status was lost, it is updated instead.
+func (c *gcpClient) createManagedCertificate(ctx context.Context, req *Request, name string) (*Certificate, error) {
+	spec := req.Target.GCP
+	id := managedCertificateID(spec, name)
+	op := new(gcpManagedOperation)
+
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("projects/%s/locations/%s/certificates", spec.Project, gcpLocation(spec)), url.Values{
+		"certificateId": {name},
+	}, managedCertificateBody(req), op)
+
+	if gcpStatusCode(err) == http.StatusConflict {
+		existing, err := c.getManagedCertificate(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing.Labels[gcpManagedLabel] != gcpManagedLabelValue {
+			return nil, fmt.Errorf("%w: %s", ErrNotManaged, id)
+		}
+
+		return c.updateManagedCertificate(ctx, req, id)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	if err := c.waitManagedOperation(ctx, op); err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return c.describeManagedCertificate(ctx, id)
+}
+
+func (c *gcpClient) updateManagedCertificate(ctx context.Context, req *Request, id string) (*Certificate, error) {
+	op := new(gcpManagedOperation)
+
+	err := c.do(ctx, http.MethodPatch, id, url.Values{
+		"updateMask": {"selfManaged,description,labels"},
+	}, managedCertificateBody(req), op)
+	if err != nil {
+		if gcpStatusCode(err) == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		return nil, fmt.Errorf("failed to update certificate: %w", err)
+	}
+
+	if err := c.waitManagedOperation(ctx, op); err != nil {
+		return nil, fmt.Errorf("failed to update certificate: %w", err)
+	}
+
+	return c.describeManagedCertificate(ctx, id)
+}
+
+func (c *gcpClient) getManagedCertificate(ctx context.Context, id string) (*gcpManagedCertificate, error) {
+	cert := new(gcpManagedCertificate)
+
+	if err := c.do(ctx, http.MethodGet, id, nil, nil, cert); err != nil {
+		if gcpStatusCode(err) == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+func (c *gcpClient) describeManagedCertificate(ctx context.Context, id string) (*Certificate, error) {
+	cert, err := c.getManagedCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		ID:         id,
+		UploadTime: parseGCPTime(cert.CreateTime),
+		UpdateTime: parseGCPTime(cert.UpdateTime),
+		ExpireTime: parseGCPTime(cert.ExpireTime),
+		Hosts:      cert.SANDNSNames,
+		State:      CertificateStateActive,
+	}, nil
+}
+
+func (c *gcpClient) deleteManagedCertificate(ctx context.Context, id string) error {
+	cert, err := c.getManagedCertificate(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	if cert.Labels[gcpManagedLabel] != gcpManagedLabelValue {
+		return fmt.Errorf("%w: %s", ErrNotManaged, id)
+	}
+
+	op := new(gcpManagedOperation)
+
+	if err := c.do(ctx, http.MethodDelete, id, nil, nil, op); err != nil {
+		if gcpStatusCode(err) == http.StatusNotFound {
+			return nil
+		}
+
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+
+	if err := c.waitManagedOperation(ctx, op); err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+
+	return nil
+}
+
+// waitManagedOperation polls a long-running operation of Certificate Manager
+// until it's done.
+func (c *gcpClient) waitManagedOperation(ctx context.Context, op *gcpManagedOperation) error {
+	ctx, cancel := context.WithTimeout(ctx, gcpOperationTimeout)
+	defer cancel()
+
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return &RetryableError{Err: fmt.Errorf("operation %s is not done: %w", op.Name, ctx.Err())}
+		case <-time.After(gcpOperationInterval):
+		}
+
+		if err := c.do(ctx, http.MethodGet, op.Name, nil, nil, op); err != nil {
+			return fmt.Errorf("failed to get operation: %w", err)
+		}
+	}
+
+	if op.Error == nil {
+		return nil
+	}
+
+	err := fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Message)
+
+	if gcpRetryableOperationCodes[op.Error.Code] {
+		return &RetryableError{Err: err}
+	}
+
+	return err
+}
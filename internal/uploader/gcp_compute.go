@@ -0,0 +1,286 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// computeOperationDone is the status of finished Compute Engine operations.
+	computeOperationDone = "DONE"
+
+	// computeVersionLength is the length of versions in names, which fits
+	// nanoseconds in base 36 until the 22nd century.
+	computeVersionLength = 13
+
+	// computeResourceInUse is the reason of errors deleting certificates used
+	// by target proxies.
+	computeResourceInUse = "resourceInUseByAnotherResource"
+)
+
+type computeSSLCertificate struct {
+	Name                    string   `json:"name"`
+	Description             string   `json:"description,omitempty"`
+	Certificate             string   `json:"certificate,omitempty"`
+	PrivateKey              string   `json:"privateKey,omitempty"`
+	CreationTimestamp       string   `json:"creationTimestamp,omitempty"`
+	ExpireTime              string   `json:"expireTime,omitempty"`
+	SubjectAlternativeNames []string `json:"subjectAlternativeNames,omitempty"`
+}
+
+type computeOperation struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// computeParent returns the path of the project and the region, or of the
+// project only for global certificates.
+func computeParent(spec *v1alpha1.GCPUploadSpec) string {
+	if location := gcpLocation(spec); location != v1alpha1.GCPLocationGlobal {
+		return fmt.Sprintf("projects/%s/regions/%s", spec.Project, location)
+	}
+
+	return fmt.Sprintf("projects/%s/global", spec.Project)
+}
+
+// computeVersionedName returns the prefix followed by a version, which is the
+// current time in base 36 padded to a fixed width so names are sortable and
+// unique.
+func computeVersionedName(prefix string) string {
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	return prefix + "-" + strings.Repeat("0", computeVersionLength-len(version)) + version
+}
+
+// isComputeVersion returns true if the name is a version of the prefix
+// generated by computeVersionedName.
+func isComputeVersion(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix+"-") {
+		return false
+	}
+
+	version := name[len(prefix)+1:]
+
+	if len(version) != computeVersionLength {
+		return false
+	}
+
+	for _, c := range version {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// computeManagedMarker is appended to descriptions of SSL certificates
+// created by the controller, because they don't have labels. It contains a
+// hash of the owner, so versions of uploads sharing a name are told apart.
+func computeManagedMarker(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+
+	return fmt.Sprintf("(managed by cert-uploader %s)", hex.EncodeToString(sum[:8]))
+}
+
+func computeDescription(req *Request) string {
+	return strings.TrimSpace(req.Target.GCP.Description + " " + computeManagedMarker(req.Owner))
+}
+
+// isComputeManaged returns true if the certificate was created by the
+// controller for the owner.
+func isComputeManaged(cert *computeSSLCertificate, owner string) bool {
+	return strings.HasSuffix(cert.Description, computeManagedMarker(owner))
+}
+
+// computeNamePrefix returns the prefix of a versioned name.
+func computeNamePrefix(name string) string {
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+
+	return name
+}
+
+// insertSSLCertificate creates a new version of the certificate.
+func (c *gcpClient) insertSSLCertificate(ctx context.Context, req *Request, parent, prefix string) (*Certificate, error) {
+	name := computeVersionedName(prefix)
+	op := new(computeOperation)
+
+	err := c.do(ctx, http.MethodPost, parent+"/sslCertificates", nil, &computeSSLCertificate{
+		Name:        name,
+		Description: computeDescription(req),
+		Certificate: string(req.Secret.Data[corev1.TLSCertKey]),
+		PrivateKey:  string(req.Secret.Data[corev1.TLSPrivateKeyKey]),
+	}, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssl certificate: %w", err)
+	}
+
+	if err := c.waitComputeOperation(ctx, parent, op); err != nil {
+		return nil, fmt.Errorf("failed to create ssl certificate: %w", err)
+	}
+
+	return c.describeSSLCertificate(ctx, parent+"/sslCertificates/"+name)
+}
+
+// deleteOldSSLCertificates deletes versions of the certificate which are
+// older than the named one and were created by the controller for the owner.
+// Versions still
+// used by target proxies can't be deleted, so every version is tried and the
+// first error is returned.
+func (c *gcpClient) deleteOldSSLCertificates(ctx context.Context, parent, name, owner string) error {
+	prefix := computeNamePrefix(name)
+	query := url.Values{}
+
+	var firstErr error
+
+	for {
+		var list struct {
+			Items         []computeSSLCertificate `json:"items"`
+			NextPageToken string                  `json:"nextPageToken"`
+		}
+
+		if err := c.do(ctx, http.MethodGet, parent+"/sslCertificates", query, nil, &list); err != nil {
+			return fmt.Errorf("failed to list ssl certificates: %w", err)
+		}
+
+		for _, item := range list.Items {
+			if item.Name >= name || !isComputeVersion(item.Name, prefix) || !isComputeManaged(&item, owner) {
+				continue
+			}
+
+			if err := c.deleteSSLCertificate(ctx, parent+"/sslCertificates/"+item.Name); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if list.NextPageToken == "" {
+			return firstErr
+		}
+
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+func (c *gcpClient) getSSLCertificate(ctx context.Context, id string) (*computeSSLCertificate, error) {
+	cert := new(computeSSLCertificate)
+
+	if err := c.do(ctx, http.MethodGet, id, nil, nil, cert); err != nil {
+		if gcpStatusCode(err) == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+
+		return nil, fmt.Errorf("failed to get ssl certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+func (c *gcpClient) describeSSLCertificate(ctx context.Context, id string) (*Certificate, error) {
+	cert, err := c.getSSLCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	created := parseGCPTime(cert.CreationTimestamp)
+
+	return &Certificate{
+		ID:         id,
+		UploadTime: created,
+		UpdateTime: created,
+		ExpireTime: parseGCPTime(cert.ExpireTime),
+		Hosts:      cert.SubjectAlternativeNames,
+		State:      CertificateStateActive,
+	}, nil
+}
+
+// deleteManagedSSLCertificate deletes an SSL certificate if it was created by
+// the controller for the owner. Older versions left by failed deletes, e.g. because target
+// proxies were still using them, are deleted too.
+func (c *gcpClient) deleteManagedSSLCertificate(ctx context.Context, id, owner string) error {
+	cert, err := c.getSSLCertificate(ctx, id)
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return err
+	case !isComputeManaged(cert, owner):
+		return fmt.Errorf("%w: %s", ErrNotManaged, id)
+	default:
+		if err := c.deleteSSLCertificate(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return c.deleteOldSSLCertificates(ctx, path.Dir(path.Dir(id)), path.Base(id), owner)
+}
+
+func (c *gcpClient) deleteSSLCertificate(ctx context.Context, id string) error {
+	op := new(computeOperation)
+
+	if err := c.do(ctx, http.MethodDelete, id, nil, nil, op); err != nil {
+		switch {
+		case gcpStatusCode(err) == http.StatusNotFound:
+			return nil
+		case gcpErrorReason(err) == computeResourceInUse:
+			// The certificate can be deleted after target proxies stop using it
+			return &RetryableError{Err: fmt.Errorf("failed to delete ssl certificate: %w", err)}
+		}
+
+		return fmt.Errorf("failed to delete ssl certificate: %w", err)
+	}
+
+	if err := c.waitComputeOperation(ctx, path.Dir(path.Dir(id)), op); err != nil {
+		return fmt.Errorf("failed to delete ssl certificate: %w", err)
+	}
+
+	return nil
+}
+
+// waitComputeOperation waits until a Compute Engine operation is done. The
+// wait method returns when the operation is done or after about two minutes.
+func (c *gcpClient) waitComputeOperation(ctx context.Context, parent string, op *computeOperation) error {
+	ctx, cancel := context.WithTimeout(ctx, gcpOperationTimeout)
+	defer cancel()
+
+	for op.Status != computeOperationDone {
+		if err := ctx.Err(); err != nil {
+			return &RetryableError{Err: fmt.Errorf("operation %s is not done: %w", op.Name, err)}
+		}
+
+		if err := c.do(ctx, http.MethodPost, parent+"/operations/"+op.Name+"/wait", nil, nil, op); err != nil {
+			return fmt.Errorf("failed to wait for operation: %w", err)
+		}
+	}
+
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(op.Error.Errors))
+
+	for i, e := range op.Error.Errors {
+		messages[i] = fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+
+	return fmt.Errorf("operation %s failed: %s", op.Name, strings.Join(messages, "; "))
+}
@@ -0,0 +1,1108 @@
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testGCPAccessToken = "gcp-token"
+
+	// testGCPPageSize is the number of SSL certificates in a page, so lists
+	// are paginated in tests.
+	testGCPPageSize = 2
+)
+
+type gcpFailure struct {
+	StatusCode int
+	RetryAfter string
+}
+
+// fakeGCP is a fake of the token endpoint, the Certificate Manager API and
+// the Compute Engine API, which stores certificates in memory.
+type fakeGCP struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	managed     map[string]*gcpManagedCertificate
+	updates     map[string]int
+	compute     map[string]*computeSSLCertificate
+	inUse       map[string]bool
+	failures    map[string]gcpFailure
+	tokenStatus int
+	tokens      int
+	operations  int
+}
+
+func newFakeGCP() *fakeGCP {
+	f := &fakeGCP{
+		managed:  map[string]*gcpManagedCertificate{},
+		updates:  map[string]int{},
+		compute:  map[string]*computeSSLCertificate{},
+		inUse:    map[string]bool{},
+		failures: map[string]gcpFailure{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+
+	return f
+}
+
+func (f *fakeGCP) Close() {
+	f.server.Close()
+}
+
+func (f *fakeGCP) certificateManagerURL() string {
+	return f.server.URL + "/certificatemanager/v1/"
+}
+
+func (f *fakeGCP) computeURL() string {
+	return f.server.URL + "/compute/v1/"
+}
+
+func (f *fakeGCP) managedCertificate(id string) *gcpManagedCertificate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.managed[id]
+}
+
+func (f *fakeGCP) managedUpdates(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.updates[id]
+}
+
+func (f *fakeGCP) sslCertificate(id string) *computeSSLCertificate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.compute[id]
+}
+
+// setInUse sets whether an SSL certificate is used by a target proxy, so it
+// can't be deleted.
+func (f *fakeGCP) setInUse(id string, inUse bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inUse[id] = inUse
+}
+
+// fail makes requests to the path fail with the status code. The failure is
+// removed if the status code is zero.
+func (f *fakeGCP) fail(path string, failure gcpFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if failure.StatusCode == 0 {
+		delete(f.failures, path)
+	} else {
+		f.failures[path] = failure
+	}
+}
+
+func (f *fakeGCP) failTokens(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokenStatus = status
+}
+
+func (f *fakeGCP) tokenRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.tokens
+}
+
+func (f *fakeGCP) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.handleToken(w)
+
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+testGCPAccessToken {
+		writeGCPError(w, http.StatusUnauthorized, "", "invalid access token")
+
+		return
+	}
+
+	if failure, ok := f.failures[r.URL.Path]; ok {
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+
+		writeGCPError(w, failure.StatusCode, "", "injected failure")
+
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/certificatemanager/v1/"):
+		f.handleCertificateManager(w, r, strings.TrimPrefix(r.URL.Path, "/certificatemanager/v1/"))
+	case strings.HasPrefix(r.URL.Path, "/compute/v1/"):
+		f.handleCompute(w, r, strings.TrimPrefix(r.URL.Path, "/compute/v1/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGCP) handleToken(w http.ResponseWriter) {
+	if f.tokenStatus != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.tokenStatus)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"invalid JWT signature"}`))
+
+		return
+	}
+
+	f.tokens++
+	writeGCPResponse(w, map[string]interface{}{
+		"access_token": testGCPAccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (f *fakeGCP) handleCertificateManager(w http.ResponseWriter, r *http.Request, p string) {
+	if strings.HasPrefix(p, "operations/") {
+		writeGCPResponse(w, &gcpManagedOperation{Name: p, Done: true})
+
+		return
+	}
+
+	id := p
+	if r.Method == http.MethodPost {
+		id = p + "/" + r.URL.Query().Get("certificateId")
+	}
+
+	cert := f.managed[id]
+
+	if r.Method != http.MethodPost && cert == nil {
+		writeGCPError(w, http.StatusNotFound, "", id+" not found")
+
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	switch r.Method {
+	case http.MethodPost:
+		if cert != nil {
+			writeGCPError(w, http.StatusConflict, "", id+" already exists")
+
+			return
+		}
+
+		cert = new(gcpManagedCertificate)
+		cert.CreateTime = now
+		f.managed[id] = cert
+
+		if !f.updateManagedCertificate(w, r, cert, now) {
+			delete(f.managed, id)
+
+			return
+		}
+
+	case http.MethodPatch:
+		if r.URL.Query().Get("updateMask") == "" {
+			writeGCPError(w, http.StatusBadRequest, "", "updateMask is required")
+
+			return
+		}
+
+		if !f.updateManagedCertificate(w, r, cert, now) {
+			return
+		}
+
+		f.updates[id]++
+
+	case http.MethodGet:
+		writeGCPResponse(w, cert)
+
+		return
+
+	case http.MethodDelete:
+		delete(f.managed, id)
+
+	default:
+		http.Error(w, "unknown method "+r.Method, http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	f.operations++
+	writeGCPResponse(w, &gcpManagedOperation{Name: fmt.Sprintf("operations/%d", f.operations), Done: true})
+}
+
+func (f *fakeGCP) updateManagedCertificate(w http.ResponseWriter, r *http.Request, cert *gcpManagedCertificate, now string) bool {
+	var body gcpManagedCertificate
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SelfManaged == nil {
+		writeGCPError(w, http.StatusBadRequest, "", "invalid certificate")
+
+		return false
+	}
+
+	x509Cert, err := parseGCPTestCertificate(body.SelfManaged.PEMCertificate)
+	if err != nil {
+		writeGCPError(w, http.StatusBadRequest, "", err.Error())
+
+		return false
+	}
+
+	cert.Description = body.Description
+	cert.Labels = body.Labels
+	cert.SANDNSNames = x509Cert.DNSNames
+	cert.ExpireTime = x509Cert.NotAfter.UTC().Format(time.RFC3339Nano)
+	cert.UpdateTime = now
+
+	return true
+}
+
+func (f *fakeGCP) handleCompute(w http.ResponseWriter, r *http.Request, p string) {
+	if r.Method == http.MethodPost && path.Base(p) == "wait" {
+		writeGCPResponse(w, &computeOperation{Name: path.Base(path.Dir(p)), Status: computeOperationDone})
+
+		return
+	}
+
+	if path.Base(p) == "sslCertificates" {
+		switch r.Method {
+		case http.MethodPost:
+			f.insertSSLCertificate(w, r, p)
+		case http.MethodGet:
+			f.listSSLCertificates(w, r, p)
+		default:
+			http.Error(w, "unknown method "+r.Method, http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	cert := f.compute[p]
+
+	if cert == nil {
+		writeGCPError(w, http.StatusNotFound, "notFound", p+" not found")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeGCPResponse(w, cert)
+
+	case http.MethodDelete:
+		if f.inUse[p] {
+			writeGCPError(w, http.StatusBadRequest, computeResourceInUse, p+" is used by a target proxy")
+
+			return
+		}
+
+		delete(f.compute, p)
+		f.writeComputeOperation(w)
+
+	default:
+		http.Error(w, "unknown method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeGCP) insertSSLCertificate(w http.ResponseWriter, r *http.Request, collection string) {
+	var body computeSSLCertificate
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PrivateKey == "" {
+		writeGCPError(w, http.StatusBadRequest, "invalid", "invalid certificate")
+
+		return
+	}
+
+	id := collection + "/" + body.Name
+
+	if f.compute[id] != nil {
+		writeGCPError(w, http.StatusConflict, "alreadyExists", id+" already exists")
+
+		return
+	}
+
+	x509Cert, err := parseGCPTestCertificate(body.Certificate)
+	if err != nil {
+		writeGCPError(w, http.StatusBadRequest, "invalid", err.Error())
+
+		return
+	}
+
+	f.compute[id] = &computeSSLCertificate{
+		Name:                    body.Name,
+		Description:             body.Description,
+		CreationTimestamp:       time.Now().UTC().Format(time.RFC3339Nano),
+		ExpireTime:              x509Cert.NotAfter.UTC().Format(time.RFC3339Nano),
+		SubjectAlternativeNames: x509Cert.DNSNames,
+	}
+
+	f.writeComputeOperation(w)
+}
+
+func (f *fakeGCP) listSSLCertificates(w http.ResponseWriter, r *http.Request, collection string) {
+	var names []string
+
+	for id, cert := range f.compute {
+		if path.Dir(id) == collection {
+			names = append(names, cert.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	end := start + testGCPPageSize
+	response := map[string]interface{}{}
+
+	if end < len(names) {
+		response["nextPageToken"] = strconv.Itoa(end)
+	} else {
+		end = len(names)
+	}
+
+	items := []*computeSSLCertificate{}
+
+	for _, name := range names[start:end] {
+		items = append(items, f.compute[collection+"/"+name])
+	}
+
+	response["items"] = items
+	writeGCPResponse(w, response)
+}
+
+// writeComputeOperation writes an operation which is still running, so it's
+// waited for.
+func (f *fakeGCP) writeComputeOperation(w http.ResponseWriter) {
+	f.operations++
+	writeGCPResponse(w, &computeOperation{Name: fmt.Sprintf("operation-%d", f.operations), Status: "RUNNING"})
+}
+
+func writeGCPResponse(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeGCPError(w http.ResponseWriter, status int, reason, message string) {
+	body := map[string]interface{}{
+		"code":    status,
+		"message": message,
+	}
+
+	if reason != "" {
+		body["errors"] = []map[string]string{{"reason": reason, "message": message}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+func parseGCPTestCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid PEM certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// newGCPServiceAccountKey returns a JSON key of a service account, which gets
+// tokens from the fake.
+func newGCPServiceAccountKey(t *testing.T, f *fakeGCP) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&gcpServiceAccountKey{
+		Type:         "service_account",
+		ClientEmail:  "cert-uploader@p.iam.gserviceaccount.com",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		PrivateKeyID: "key-id",
+		TokenURI:     f.server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func newTestGCP(t *testing.T, f *fakeGCP) *GCP {
+	t.Helper()
+
+	return &GCP{
+		Client: fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "gcp"},
+			Data: map[string][]byte{
+				"key.json": []byte(newGCPServiceAccountKey(t, f)),
+				"invalid":  []byte(`{"type":"authorized_user"}`),
+			},
+		}).Build(),
+		CertificateManagerURL: f.certificateManagerURL(),
+		ComputeURL:            f.computeURL(),
+	}
+}
+
+func newGCPRequest(t *testing.T, spec v1alpha1.GCPUploadSpec, hosts ...string) *Request {
+	t.Helper()
+
+	if spec.Project == "" {
+		spec.Project = "p"
+	}
+
+	if spec.ServiceAccountKeySecretRef == nil {
+		spec.ServiceAccountKeySecretRef = &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "gcp"},
+			Key:                  "key.json",
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{"www.example.com"}
+	}
+
+	return &Request{
+		Namespace: "default",
+		Secret:    newTestTLSSecret(t, hosts...),
+		Target:    &v1alpha1.UploadTarget{Name: "gcp", GCP: &spec},
+		CacheKey:  "default/gcp",
+		Owner:     "CertificateUpload/default/foo/gcp",
+	}
+}
+
+func TestGCPCertificateManager(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGCP()
+	defer f.Close()
+
+	g := newTestGCP(t, f)
+	req := newGCPRequest(t, v1alpha1.GCPUploadSpec{
+		Name:        "cert",
+		Description: "Example",
+		Labels:      map[string]string{"team": "web"},
+	})
+
+	cert, err := g.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const id = "projects/p/locations/global/certificates/cert"
+
+	if cert.ID != id || !reflect.DeepEqual(cert.Hosts, []string{"www.example.com"}) || cert.ExpireTime.IsZero() {
+		t.Fatalf("unexpected certificate: %+v", cert)
+	}
+
+	stored := f.managedCertificate(id)
+	if stored == nil || stored.Description != "Example" || !reflect.DeepEqual(stored.Labels, map[string]string{
+		"team":          "web",
+		gcpManagedLabel: gcpManagedLabelValue,
+	}) {
+		t.Fatalf("unexpected certificate: %+v", stored)
+	}
+
+	t.Run("describe", func(t *testing.T) {
+		actual, err := g.Describe(ctx, req, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != id || !actual.ExpireTime.Equal(cert.ExpireTime) || actual.UploadTime.IsZero() {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("describe missing", func(t *testing.T) {
+		if _, err := g.Describe(ctx, req, "projects/p/locations/global/certificates/missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		updateReq := newGCPRequest(t, *req.Target.GCP, "www.example.com", "example.com")
+
+		actual, err := g.Update(ctx, updateReq, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != id || len(actual.Hosts) != 2 || f.managedUpdates(id) != 1 {
+			t.Fatalf("expected certificate %s to be updated, got %+v", id, actual)
+		}
+	})
+
+	t.Run("update renamed", func(t *testing.T) {
+		spec := *req.Target.GCP
+		spec.Name = "renamed"
+
+		actual, err := g.Update(ctx, newGCPRequest(t, spec), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != "projects/p/locations/global/certificates/renamed" || f.managedCertificate(actual.ID) == nil {
+			t.Fatalf("expected a renamed certificate, got %+v", actual)
+		}
+
+		// The previous certificate is left to the caller
+		if f.managedCertificate(id) == nil || f.managedUpdates(id) != 1 {
+			t.Fatalf("certificate %s is changed", id)
+		}
+	})
+
+	t.Run("update generated name", func(t *testing.T) {
+		spec := *req.Target.GCP
+		spec.Name = ""
+
+		actual, err := g.Update(ctx, newGCPRequest(t, spec), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != id || f.managedUpdates(id) != 2 {
+			t.Fatalf("expected certificate %s to be updated, got %+v", id, actual)
+		}
+	})
+
+	t.Run("update missing", func(t *testing.T) {
+		spec := *req.Target.GCP
+		spec.Name = ""
+
+		_, err := g.Update(ctx, newGCPRequest(t, spec), "projects/p/locations/global/certificates/missing")
+
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("create existing", func(t *testing.T) {
+		actual, err := g.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != id || f.managedUpdates(id) != 3 {
+			t.Fatalf("expected certificate %s to be updated, got %+v", id, actual)
+		}
+	})
+
+	t.Run("create generated name", func(t *testing.T) {
+		spec := *req.Target.GCP
+		spec.Name = ""
+
+		actual, err := g.Create(ctx, newGCPRequest(t, spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(path.Base(actual.ID), gcpNamePrefix) || f.managedCertificate(actual.ID) == nil {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("create regional", func(t *testing.T) {
+		spec := *req.Target.GCP
+		spec.Location = "us-central1"
+
+		actual, err := g.Create(ctx, newGCPRequest(t, spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != "projects/p/locations/us-central1/certificates/cert" {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("unmanaged", func(t *testing.T) {
+		const unmanagedID = "projects/p/locations/global/certificates/unmanaged"
+
+		f.mu.Lock()
+		f.managed[unmanagedID] = &gcpManagedCertificate{Labels: map[string]string{"team": "web"}}
+		f.mu.Unlock()
+
+		spec := *req.Target.GCP
+		spec.Name = "unmanaged"
+
+		if _, err := g.Create(ctx, newGCPRequest(t, spec)); !errors.Is(err, ErrNotManaged) {
+			t.Fatalf("expected ErrNotManaged when creating, got %v", err)
+		}
+
+		if err := g.Delete(ctx, req, unmanagedID); !errors.Is(err, ErrNotManaged) {
+			t.Fatalf("expected ErrNotManaged when deleting, got %v", err)
+		}
+
+		if f.managedCertificate(unmanagedID) == nil {
+			t.Fatalf("certificate %s is deleted", unmanagedID)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		// Deleting a missing certificate succeeds
+		for i := 0; i < 2; i++ {
+			if err := g.Delete(ctx, req, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if f.managedCertificate(id) != nil {
+			t.Fatalf("certificate %s is not deleted", id)
+		}
+	})
+}
+
+func TestGCPCompute(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGCP()
+	defer f.Close()
+
+	g := newTestGCP(t, f)
+	newRequest := func(name, location string) *Request {
+		return newGCPRequest(t, v1alpha1.GCPUploadSpec{
+			Type:        v1alpha1.GCPTypeCompute,
+			Name:        name,
+			Location:    location,
+			Description: "Example",
+		})
+	}
+
+	create := func(req *Request) *Certificate {
+		t.Helper()
+
+		cert, err := g.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cert
+	}
+
+	expectVersion := func(cert *Certificate, prefix string) {
+		t.Helper()
+
+		if dir := path.Dir(cert.ID); dir != "projects/p/global/sslCertificates" {
+			t.Fatalf("unexpected collection of %s: %s", cert.ID, dir)
+		}
+
+		if !isComputeVersion(path.Base(cert.ID), prefix) {
+			t.Fatalf("expected a version of %s, got %s", prefix, cert.ID)
+		}
+	}
+
+	req := newRequest("cert", "")
+	cert := create(req)
+
+	expectVersion(cert, "cert")
+
+	if !reflect.DeepEqual(cert.Hosts, []string{"www.example.com"}) || cert.ExpireTime.IsZero() || cert.UploadTime.IsZero() {
+		t.Fatalf("unexpected certificate: %+v", cert)
+	}
+
+	if stored := f.sslCertificate(cert.ID); stored == nil || stored.Description != "Example "+computeManagedMarker(req.Owner) {
+		t.Fatalf("unexpected ssl certificate: %+v", stored)
+	}
+
+	t.Run("describe", func(t *testing.T) {
+		actual, err := g.Describe(ctx, req, cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.ID != cert.ID || !actual.ExpireTime.Equal(cert.ExpireTime) {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("describe missing", func(t *testing.T) {
+		if _, err := g.Describe(ctx, req, "projects/p/global/sslCertificates/missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		actual, err := g.Update(ctx, req, cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectVersion(actual, "cert")
+
+		if actual.ID == cert.ID {
+			t.Fatalf("expected a new version, got %s", actual.ID)
+		}
+
+		// The previous version is left to the caller
+		if f.sslCertificate(cert.ID) == nil {
+			t.Fatalf("ssl certificate %s is deleted", cert.ID)
+		}
+	})
+
+	t.Run("update renamed", func(t *testing.T) {
+		actual, err := g.Update(ctx, newRequest("renamed", ""), cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectVersion(actual, "renamed")
+	})
+
+	t.Run("update generated name", func(t *testing.T) {
+		actual, err := g.Update(ctx, newRequest("", ""), cert.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectVersion(actual, "cert")
+	})
+
+	t.Run("create generated name", func(t *testing.T) {
+		actual := create(newRequest("", ""))
+
+		if !strings.HasPrefix(path.Base(actual.ID), gcpNamePrefix) {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("create regional", func(t *testing.T) {
+		actual := create(newRequest("cert", "us-central1"))
+
+		if dir := path.Dir(actual.ID); dir != "projects/p/regions/us-central1/sslCertificates" {
+			t.Fatalf("unexpected certificate: %+v", actual)
+		}
+	})
+
+	t.Run("delete old versions", func(t *testing.T) {
+		sweepReq := newRequest("sweep", "")
+		versions := []*Certificate{create(sweepReq), create(sweepReq), create(sweepReq)}
+
+		// Certificates with the same prefix which weren't created by the
+		// controller are kept
+		unmanagedID := path.Dir(versions[0].ID) + "/sweep-" + strings.Repeat("0", computeVersionLength)
+
+		f.mu.Lock()
+		f.compute[unmanagedID] = &computeSSLCertificate{Name: path.Base(unmanagedID), Description: "Unmanaged"}
+		f.mu.Unlock()
+
+		if err := g.Delete(ctx, sweepReq, versions[1].ID); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, deleted := range []bool{true, true, false} {
+			if actual := f.sslCertificate(versions[i].ID) == nil; actual != deleted {
+				t.Errorf("expected deleted of %s to be %v, got %v", versions[i].ID, deleted, actual)
+			}
+		}
+
+		if f.sslCertificate(unmanagedID) == nil {
+			t.Errorf("unmanaged ssl certificate %s is deleted", unmanagedID)
+		}
+
+		if f.sslCertificate(cert.ID) == nil {
+			t.Errorf("ssl certificate %s of another prefix is deleted", cert.ID)
+		}
+	})
+
+	t.Run("delete in use", func(t *testing.T) {
+		usedReq := newRequest("used", "")
+		prev := create(usedReq)
+		current := create(usedReq)
+
+		f.setInUse(prev.ID, true)
+
+		// The current version is deleted, while the previous version is
+		// retried
+		if err := g.Delete(ctx, usedReq, current.ID); !IsRetryable(err) {
+			t.Fatalf("expected a retryable error, got %v", err)
+		}
+
+		if f.sslCertificate(current.ID) != nil || f.sslCertificate(prev.ID) == nil {
+			t.Fatalf("expected only %s to be deleted", current.ID)
+		}
+
+		if err := g.Delete(ctx, usedReq, prev.ID); !IsRetryable(err) {
+			t.Fatalf("expected a retryable error, got %v", err)
+		}
+
+		f.setInUse(prev.ID, false)
+
+		if err := g.Delete(ctx, usedReq, current.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if f.sslCertificate(prev.ID) != nil {
+			t.Fatalf("ssl certificate %s is not deleted", prev.ID)
+		}
+	})
+
+	t.Run("shared name", func(t *testing.T) {
+		reqA := newRequest("shared", "")
+		reqB := newRequest("shared", "")
+		reqB.Owner = "CertificateUpload/default/bar/gcp"
+
+		prevA := create(reqA)
+		prevB := create(reqB)
+		currentA := create(reqA)
+		currentB := create(reqB)
+
+		// Only versions of the same owner are deleted
+		if err := g.Delete(ctx, reqA, currentA.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if f.sslCertificate(prevA.ID) != nil || f.sslCertificate(currentA.ID) != nil {
+			t.Fatal("ssl certificates of the first upload are not deleted")
+		}
+
+		if f.sslCertificate(prevB.ID) == nil || f.sslCertificate(currentB.ID) == nil {
+			t.Fatal("ssl certificates of the second upload are deleted")
+		}
+
+		if err := g.Delete(ctx, reqA, currentB.ID); !errors.Is(err, ErrNotManaged) {
+			t.Fatalf("expected ErrNotManaged, got %v", err)
+		}
+
+		if f.sslCertificate(currentB.ID) == nil {
+			t.Fatalf("ssl certificate %s is deleted", currentB.ID)
+		}
+	})
+
+	t.Run("delete unmanaged", func(t *testing.T) {
+		const unmanagedID = "projects/p/global/sslCertificates/unmanaged"
+
+		f.mu.Lock()
+		f.compute[unmanagedID] = &computeSSLCertificate{Name: "unmanaged"}
+		f.mu.Unlock()
+
+		if err := g.Delete(ctx, req, unmanagedID); !errors.Is(err, ErrNotManaged) {
+			t.Fatalf("expected ErrNotManaged, got %v", err)
+		}
+
+		if f.sslCertificate(unmanagedID) == nil {
+			t.Fatalf("ssl certificate %s is deleted", unmanagedID)
+		}
+	})
+}
+
+func TestGCPErrors(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGCP()
+	defer f.Close()
+
+	g := newTestGCP(t, f)
+	req := newGCPRequest(t, v1alpha1.GCPUploadSpec{Name: "cert"})
+
+	const id = "projects/p/locations/global/certificates/cert"
+
+	tests := []struct {
+		name              string
+		failure           gcpFailure
+		credentials       bool
+		insufficientPerms bool
+		retryable         bool
+		retryAfter        time.Duration
+	}{
+		{
+			name:        "unauthorized",
+			failure:     gcpFailure{StatusCode: http.StatusUnauthorized},
+			credentials: true,
+		},
+		{
+			name:              "forbidden",
+			failure:           gcpFailure{StatusCode: http.StatusForbidden},
+			credentials:       true,
+			insufficientPerms: true,
+		},
+		{
+			name:       "too many requests",
+			failure:    gcpFailure{StatusCode: http.StatusTooManyRequests, RetryAfter: "30"},
+			retryable:  true,
+			retryAfter: 30 * time.Second,
+		},
+		{
+			name:      "service unavailable",
+			failure:   gcpFailure{StatusCode: http.StatusServiceUnavailable},
+			retryable: true,
+		},
+		{
+			name:    "bad request",
+			failure: gcpFailure{StatusCode: http.StatusBadRequest},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			f.fail("/certificatemanager/v1/"+id, test.failure)
+			defer f.fail("/certificatemanager/v1/"+id, gcpFailure{})
+
+			_, err := g.Describe(ctx, req, id)
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if actual := gcpStatusCode(err); actual != test.failure.StatusCode {
+				t.Errorf("expected status code %d, got %d", test.failure.StatusCode, actual)
+			}
+
+			if actual := IsCredentialsError(err); actual != test.credentials {
+				t.Errorf("expected IsCredentialsError to be %v, got %v", test.credentials, actual)
+			}
+
+			if actual := IsInsufficientPermissions(err); actual != test.insufficientPerms {
+				t.Errorf("expected IsInsufficientPermissions to be %v, got %v", test.insufficientPerms, actual)
+			}
+
+			if actual := IsRetryable(err); actual != test.retryable {
+				t.Errorf("expected IsRetryable to be %v, got %v", test.retryable, actual)
+			}
+
+			if actual := RetryAfter(err); actual != test.retryAfter {
+				t.Errorf("expected RetryAfter to be %v, got %v", test.retryAfter, actual)
+			}
+		})
+	}
+
+	t.Run("invalid spec", func(t *testing.T) {
+		specTests := []struct {
+			name string
+			spec v1alpha1.GCPUploadSpec
+			err  error
+		}{
+			{name: "missing project", spec: v1alpha1.GCPUploadSpec{Project: ""}, err: ErrMissingGCPProject},
+			{name: "invalid type", spec: v1alpha1.GCPUploadSpec{Project: "p", Type: "Storage"}, err: ErrInvalidGCPType},
+		}
+
+		for _, test := range specTests {
+			invalidReq := newGCPRequest(t, test.spec)
+			invalidReq.Target.GCP.Project = test.spec.Project
+
+			if _, err := g.Create(ctx, invalidReq); !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+		}
+	})
+}
+
+func TestGCPToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("valid key", func(t *testing.T) {
+		f := newFakeGCP()
+		defer f.Close()
+
+		g := newTestGCP(t, f)
+		req := newGCPRequest(t, v1alpha1.GCPUploadSpec{})
+
+		// The token is cached with the client
+		for i := 0; i < 2; i++ {
+			if err := g.Verify(ctx, req); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if actual := f.tokenRequests(); actual != 1 {
+			t.Fatalf("expected 1 token request, got %d", actual)
+		}
+	})
+
+	t.Run("token request canceled", func(t *testing.T) {
+		f := newFakeGCP()
+		defer f.Close()
+
+		g := newTestGCP(t, f)
+		req := newGCPRequest(t, v1alpha1.GCPUploadSpec{})
+
+		// The client is created with a context which is canceled afterwards,
+		// which doesn't affect tokens requested later
+		canceledCtx, cancel := context.WithCancel(ctx)
+
+		if _, err := g.newClient(canceledCtx, req); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+
+		if err := g.Verify(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("token failure", func(t *testing.T) {
+		f := newFakeGCP()
+		defer f.Close()
+
+		f.failTokens(http.StatusBadRequest)
+
+		g := newTestGCP(t, f)
+		req := newGCPRequest(t, v1alpha1.GCPUploadSpec{Name: "cert"})
+
+		if err := g.Verify(ctx, req); !IsCredentialsError(err) {
+			t.Fatalf("expected a credentials error when verifying, got %v", err)
+		}
+
+		if _, err := g.Create(ctx, req); !IsCredentialsError(err) {
+			t.Fatalf("expected a credentials error when creating, got %v", err)
+		}
+
+		if f.managedCertificate("projects/p/locations/global/certificates/cert") != nil {
+			t.Fatal("certificate is created")
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		f := newFakeGCP()
+		defer f.Close()
+
+		g := newTestGCP(t, f)
+		req := newGCPRequest(t, v1alpha1.GCPUploadSpec{
+			ServiceAccountKeySecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "gcp"},
+				Key:                  "invalid",
+			},
+		})
+
+		err := g.Verify(ctx, req)
+
+		if !IsCredentialsError(err) || !errors.Is(err, ErrInvalidGCPServiceAccountKey) {
+			t.Fatalf("expected ErrInvalidGCPServiceAccountKey, got %v", err)
+		}
+
+		if actual := f.tokenRequests(); actual != 0 {
+			t.Fatalf("expected no token requests, got %d", actual)
+		}
+	})
+}
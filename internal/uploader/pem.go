@@ -0,0 +1,61 @@
+package uploader
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCertificatePEM = errors.New("no certificate found in PEM data")
+
+// splitCertificateChain splits PEM encoded certificates into the leaf
+// certificate and the rest of the chain.
+func splitCertificateChain(data []byte) ([]byte, []byte, error) {
+	var (
+		leaf  []byte
+		chain []byte
+	)
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		if leaf == nil {
+			leaf = pem.EncodeToMemory(block)
+		} else {
+			chain = append(chain, pem.EncodeToMemory(block)...)
+		}
+	}
+
+	if leaf == nil {
+		return nil, nil, ErrInvalidCertificatePEM
+	}
+
+	return leaf, chain, nil
+}
+
+// parseLeafCertificate returns the first certificate in PEM data.
+func parseLeafCertificate(data []byte) (*x509.Certificate, error) {
+	leaf, _, err := splitCertificateChain(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(leaf)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return cert, nil
+}
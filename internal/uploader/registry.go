@@ -0,0 +1,85 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrMultipleProviders is returned when a target has settings of more than one
+// provider.
+var ErrMultipleProviders = errors.New("target has more than one provider")
+
+// Registry contains uploaders by their provider name.
+type Registry struct {
+	names     []string
+	uploaders map[string]Uploader
+	disabled  map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		uploaders: map[string]Uploader{},
+		disabled:  map[string]bool{},
+	}
+}
+
+// NewDefaultRegistry returns a registry containing all built-in uploaders.
+func NewDefaultRegistry(c client.Client) *Registry {
+	r := NewRegistry()
+	r.Register("cloudflare", &Cloudflare{Client: c})
+	r.Register("acm", &ACM{Client: c})
+	r.Register("gcp", &GCP{Client: c})
+
+	return r
+}
+
+func (r *Registry) Register(name string, u Uploader) {
+	if _, ok := r.uploaders[name]; !ok {
+		r.names = append(r.names, name)
+	}
+
+	r.uploaders[name] = u
+}
+
+// Names returns names of all registered uploaders in registration order.
+func (r *Registry) Names() []string {
+	return r.names
+}
+
+func (r *Registry) Get(name string) Uploader {
+	return r.uploaders[name]
+}
+
+// Find returns the name and the uploader of the provider of the target. It
+// returns an error wrapping ErrMultipleProviders if more than one uploader is
+// configured by the target, or an empty name if none is.
+func (r *Registry) Find(target *v1alpha1.UploadTarget) (string, Uploader, error) {
+	var names []string
+
+	for _, name := range r.names {
+		if r.uploaders[name].Configured(target) {
+			names = append(names, name)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return "", nil, nil
+	case 1:
+		return names[0], r.uploaders[names[0]], nil
+	}
+
+	return "", nil, fmt.Errorf("%w: %s", ErrMultipleProviders, strings.Join(names, ", "))
+}
+
+func (r *Registry) SetEnabled(name string, enabled bool) {
+	r.disabled[name] = !enabled
+}
+
+func (r *Registry) Enabled(name string) bool {
+	return !r.disabled[name]
+}
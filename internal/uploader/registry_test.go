@@ -0,0 +1,88 @@
+package uploader_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/internal/uploader"
+	"github.com/tommy351/cert-uploader/internal/uploader/fake"
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+)
+
+func TestRegistry(t *testing.T) {
+	cloudflare := &fake.Uploader{
+		ConfiguredFunc: func(target *v1alpha1.UploadTarget) bool {
+			return target.Cloudflare != nil
+		},
+	}
+	acm := &fake.Uploader{
+		ConfiguredFunc: func(target *v1alpha1.UploadTarget) bool {
+			return target.ACM != nil
+		},
+	}
+
+	r := uploader.NewRegistry()
+	r.Register("cloudflare", cloudflare)
+	r.Register("acm", acm)
+	r.Register("cloudflare", cloudflare)
+
+	t.Run("names", func(t *testing.T) {
+		if names := r.Names(); !reflect.DeepEqual(names, []string{"cloudflare", "acm"}) {
+			t.Fatalf("unexpected names: %v", names)
+		}
+
+		if r.Get("acm") != acm || r.Get("gcp") != nil {
+			t.Fatal("unexpected uploaders")
+		}
+	})
+
+	t.Run("find", func(t *testing.T) {
+		name, u, err := r.Find(&v1alpha1.UploadTarget{ACM: &v1alpha1.ACMUploadSpec{}})
+		if name != "acm" || u != acm || err != nil {
+			t.Fatalf("expected acm, got %s, %v", name, err)
+		}
+
+		if name, u, err := r.Find(&v1alpha1.UploadTarget{}); name != "" || u != nil || err != nil {
+			t.Fatalf("expected no uploader, got %s, %v", name, err)
+		}
+	})
+
+	t.Run("multiple providers", func(t *testing.T) {
+		name, u, err := r.Find(&v1alpha1.UploadTarget{
+			Cloudflare: &v1alpha1.CloudflareUploadSpec{},
+			ACM:        &v1alpha1.ACMUploadSpec{},
+		})
+
+		if !errors.Is(err, uploader.ErrMultipleProviders) {
+			t.Fatalf("expected ErrMultipleProviders, got %v", err)
+		}
+
+		if name != "" || u != nil {
+			t.Fatalf("expected no uploader, got %s", name)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		if !r.Enabled("cloudflare") || !r.Enabled("acm") {
+			t.Fatal("uploaders should be enabled by default")
+		}
+
+		r.SetEnabled("acm", false)
+
+		if !r.Enabled("cloudflare") || r.Enabled("acm") {
+			t.Fatal("only acm should be disabled")
+		}
+
+		// Disabled uploaders are still found, so targets can be reported
+		if name, _, _ := r.Find(&v1alpha1.UploadTarget{ACM: &v1alpha1.ACMUploadSpec{}}); name != "acm" {
+			t.Fatalf("expected acm, got %s", name)
+		}
+
+		r.SetEnabled("acm", true)
+
+		if !r.Enabled("acm") {
+			t.Fatal("acm should be enabled again")
+		}
+	})
+}
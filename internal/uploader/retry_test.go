@@ -0,0 +1,33 @@
+package uploader
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: ""},
+		{value: "foo"},
+		{value: "-1"},
+		{value: "0", ok: true},
+		{value: "120", expected: 2 * time.Minute, ok: true},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), expected: time.Minute, ok: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), ok: true},
+	}
+
+	for _, test := range tests {
+		actual, ok := parseRetryAfter(test.value, now)
+
+		if actual != test.expected || ok != test.ok {
+			t.Errorf("parseRetryAfter(%q) = (%v, %v), expected (%v, %v)", test.value, actual, ok, test.expected, test.ok)
+		}
+	}
+}
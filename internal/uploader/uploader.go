@@ -0,0 +1,216 @@
+package uploader
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	ErrNotFound   = errors.New("certificate not found")
+	ErrNotManaged = errors.New("certificate is not managed by the controller")
+
+	ErrHostsNotCovered = errors.New("certificate does not cover any host of the target")
+
+	ErrMissingSecretKey = errors.New("key not found in secret")
+)
+
+// Certificate is a certificate stored on a provider.
+type Certificate struct {
+	ID         string
+	UploadTime time.Time
+	UpdateTime time.Time
+	ExpireTime time.Time
+
+	// Hosts and SerialNumber are optional. They are used for detecting drift
+	// when available.
+	Hosts        []string
+	SerialNumber string
+
+	// ZoneID and ZoneName are the zone which the certificate belongs to, if
+	// the provider has zones.
+	ZoneID   string
+	ZoneName string
+
+	// State is empty if the provider doesn't report states of certificates.
+	State CertificateState
+}
+
+// CertificateState is the state of a certificate on a provider.
+type CertificateState string
+
+const (
+	// CertificateStateActive means the certificate is being served.
+	CertificateStateActive CertificateState = "Active"
+
+	// CertificateStatePending means the certificate is being deployed.
+	CertificateStatePending CertificateState = "Pending"
+
+	// CertificateStateFailed means the certificate can't be served, e.g. it
+	// expired or was revoked.
+	CertificateStateFailed CertificateState = "Failed"
+)
+
+// Request contains the target and the certificate to upload.
+type Request struct {
+	// Namespace is where secrets referenced by the target are read from.
+	Namespace string
+	Target    *v1alpha1.UploadTarget
+	// Secret is the TLS secret. It is nil for Describe and Delete.
+	Secret *corev1.Secret
+	// Status is the last status of the target. It is nil when the target is
+	// new.
+	Status *v1alpha1.UploadTargetStatus
+	// CacheKey identifies the provider which the target refers to. API clients
+	// are cached by the key if it is not empty.
+	CacheKey string
+	// Owner identifies the resource and the target which the certificate is
+	// uploaded for. Providers which can't tag certificates use it to tell
+	// apart certificates of different targets.
+	Owner string
+}
+
+// Uploader uploads certificates to a provider.
+type Uploader interface {
+	// Name returns the human-readable name of the provider.
+	Name() string
+
+	// Configured returns true if the target has settings for the provider.
+	Configured(target *v1alpha1.UploadTarget) bool
+
+	Create(ctx context.Context, req *Request) (*Certificate, error)
+	Update(ctx context.Context, req *Request, id string) (*Certificate, error)
+
+	// Describe returns the certificate. It returns an error wrapping
+	// ErrNotFound if the certificate does not exist.
+	Describe(ctx context.Context, req *Request, id string) (*Certificate, error)
+
+	// Delete deletes the certificate. It returns nil if the certificate does
+	// not exist, or an error wrapping ErrNotManaged if the certificate was not
+	// created by the controller.
+	Delete(ctx context.Context, req *Request, id string) error
+}
+
+// Validator is implemented by uploaders which can check whether a certificate
+// is usable for a target before uploading it.
+type Validator interface {
+	// Validate returns an error wrapping ErrHostsNotCovered if the certificate
+	// can't serve any host of the target.
+	Validate(ctx context.Context, req *Request, leaf *x509.Certificate) error
+}
+
+// Verifier is implemented by uploaders which can check whether credentials
+// of a target are valid before uploading.
+type Verifier interface {
+	// Verify returns a CredentialsError if credentials are invalid.
+	Verify(ctx context.Context, req *Request) error
+}
+
+// Pinger is implemented by uploaders which can check whether the API of the
+// provider is reachable without credentials.
+type Pinger interface {
+	// Ping returns an error if the API can't be reached. Any HTTP response,
+	// including errors, means the API is reachable.
+	Ping(ctx context.Context) error
+}
+
+// CredentialsError is returned when credentials of a provider can't be
+// loaded, are rejected by the provider, or are not allowed to perform an
+// operation.
+type CredentialsError struct {
+	Err error
+
+	// InsufficientPermissions is true when credentials are valid but don't
+	// have the permissions required for the operation.
+	InsufficientPermissions bool
+}
+
+func (e *CredentialsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CredentialsError) Unwrap() error {
+	return e.Err
+}
+
+// RetryableError is returned when an operation failed temporarily and should
+// be retried, e.g. the provider is rate limiting or unavailable.
+type RetryableError struct {
+	Err error
+
+	// RetryAfter is how long the provider asks to wait before retrying. It is
+	// zero if the provider doesn't specify it.
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+func IsCredentialsError(err error) bool {
+	var e *CredentialsError
+
+	return errors.As(err, &e)
+}
+
+func IsInsufficientPermissions(err error) bool {
+	var e *CredentialsError
+
+	return errors.As(err, &e) && e.InsufficientPermissions
+}
+
+func IsRetryable(err error) bool {
+	var e *RetryableError
+
+	return errors.As(err, &e)
+}
+
+// RetryAfter returns how long the provider asks to wait before retrying, or
+// zero if the error is not retryable or the provider doesn't specify it.
+func RetryAfter(err error) time.Duration {
+	var e *RetryableError
+
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+
+	return 0
+}
+
+func getSecretValue(ctx context.Context, c client.Client, namespace string, ref *corev1.SecretKeySelector) (string, error) {
+	secret := new(corev1.Secret)
+	secretKey := types.NamespacedName{
+		Namespace: namespace,
+		Name:      ref.Name,
+	}
+
+	if err := c.Get(ctx, secretKey, secret); err != nil {
+		notFound := kerrors.IsNotFound(err)
+		err = fmt.Errorf("failed to get secret %q: %w", secretKey, err)
+
+		if !notFound {
+			err = &RetryableError{Err: err}
+		}
+
+		return "", &CredentialsError{Err: err}
+	}
+
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", &CredentialsError{Err: fmt.Errorf("%w: key %q in secret %q", ErrMissingSecretKey, ref.Key, secretKey)}
+	}
+
+	return string(value), nil
+}
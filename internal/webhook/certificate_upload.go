@@ -0,0 +1,548 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// gcpComputeMaxNameLength leaves room for the version suffix of Compute SSL
+// certificate names, which can be up to 63 characters.
+const gcpComputeMaxNameLength = 49
+
+const (
+	CertificateUploadDefaultPath  = "/mutate-cert-uploader-dev-v1alpha1-certificateupload"
+	CertificateUploadValidatePath = "/validate-cert-uploader-dev-v1alpha1-certificateupload"
+
+	ClusterCertificateUploadDefaultPath  = "/mutate-cert-uploader-dev-v1alpha1-clustercertificateupload"
+	ClusterCertificateUploadValidatePath = "/validate-cert-uploader-dev-v1alpha1-clustercertificateupload"
+)
+
+// nolint: gochecknoglobals
+var (
+	cloudflareBundleMethods = []string{
+		v1alpha1.CloudflareBundleMethodUbiquitous,
+		v1alpha1.CloudflareBundleMethodOptimal,
+		v1alpha1.CloudflareBundleMethodForce,
+	}
+	cloudflareTypes = []string{
+		v1alpha1.CloudflareTypeLegacyCustom,
+		v1alpha1.CloudflareTypeSNICustom,
+	}
+	gcpTypes = []string{
+		v1alpha1.GCPTypeCertificateManager,
+		v1alpha1.GCPTypeCompute,
+	}
+	providerKinds = []string{
+		v1alpha1.ProviderKind,
+		v1alpha1.ClusterProviderKind,
+	}
+)
+
+// +kubebuilder:webhook:path=/mutate-cert-uploader-dev-v1alpha1-certificateupload,mutating=true,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1;v1beta1,groups=cert-uploader.dev,resources=certificateuploads,verbs=create;update,versions=v1alpha1,name=mcertificateupload.cert-uploader.dev
+// +kubebuilder:webhook:path=/validate-cert-uploader-dev-v1alpha1-certificateupload,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1;v1beta1,groups=cert-uploader.dev,resources=certificateuploads,verbs=create;update,versions=v1alpha1,name=vcertificateupload.cert-uploader.dev
+// +kubebuilder:webhook:path=/mutate-cert-uploader-dev-v1alpha1-clustercertificateupload,mutating=true,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1;v1beta1,groups=cert-uploader.dev,resources=clustercertificateuploads,verbs=create;update,versions=v1alpha1,name=mclustercertificateupload.cert-uploader.dev
+// +kubebuilder:webhook:path=/validate-cert-uploader-dev-v1alpha1-clustercertificateupload,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1;v1beta1,groups=cert-uploader.dev,resources=clustercertificateuploads,verbs=create;update,versions=v1alpha1,name=vclustercertificateupload.cert-uploader.dev
+
+type CertificateUploadWebhook struct {
+	// Client reads providers referenced by targets. Targets are not validated
+	// against their providers if it is nil.
+	Client client.Reader
+
+	// ClusterResourceNamespace is where Providers referenced by
+	// ClusterCertificateUploads are read from.
+	ClusterResourceNamespace string
+
+	decoder *admission.Decoder
+}
+
+func (w *CertificateUploadWebhook) SetupWithManager(mgr manager.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return fmt.Errorf("failed to create decoder: %w", err)
+	}
+
+	w.decoder = decoder
+	server := mgr.GetWebhookServer()
+	server.Register(CertificateUploadDefaultPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleDefault)})
+	server.Register(CertificateUploadValidatePath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleValidate)})
+	server.Register(ClusterCertificateUploadDefaultPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleClusterDefault)})
+	server.Register(ClusterCertificateUploadValidatePath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleClusterValidate)})
+
+	return nil
+}
+
+func (w *CertificateUploadWebhook) decode(req admission.Request, object runtime.Object) error {
+	if err := w.decoder.Decode(req, object); err != nil {
+		return fmt.Errorf("failed to decode object: %w", err)
+	}
+
+	return nil
+}
+
+func (w *CertificateUploadWebhook) decodeOld(req admission.Request, object runtime.Object) error {
+	if err := w.decoder.DecodeRaw(req.OldObject, object); err != nil {
+		return fmt.Errorf("failed to decode old object: %w", err)
+	}
+
+	return nil
+}
+
+func (w *CertificateUploadWebhook) handleDefault(ctx context.Context, req admission.Request) admission.Response {
+	cu := new(v1alpha1.CertificateUpload)
+
+	if err := w.decode(req, cu); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	DefaultCertificateUpload(cu)
+
+	return patchResponse(req, cu)
+}
+
+func (w *CertificateUploadWebhook) handleValidate(ctx context.Context, req admission.Request) admission.Response {
+	cu := new(v1alpha1.CertificateUpload)
+
+	if err := w.decode(req, cu); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if req.Operation == admissionv1.Update {
+		old := new(v1alpha1.CertificateUpload)
+
+		if err := w.decodeOld(req, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		// Defaults are set on the new object by the mutating webhook
+		DefaultCertificateUpload(old)
+
+		if skipValidation(cu, &cu.Spec, &old.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	errs := ValidateCertificateUpload(cu)
+
+	providerErrs, err := w.validateProviders(ctx, &cu.Spec, cu.Namespace, field.NewPath("spec"))
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return validationResponse(append(errs, providerErrs...))
+}
+
+func (w *CertificateUploadWebhook) handleClusterDefault(ctx context.Context, req admission.Request) admission.Response {
+	ccu := new(v1alpha1.ClusterCertificateUpload)
+
+	if err := w.decode(req, ccu); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	defaultCertificateUploadSpec(&ccu.Spec.CertificateUploadSpec)
+
+	return patchResponse(req, ccu)
+}
+
+func (w *CertificateUploadWebhook) handleClusterValidate(ctx context.Context, req admission.Request) admission.Response {
+	ccu := new(v1alpha1.ClusterCertificateUpload)
+
+	if err := w.decode(req, ccu); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if req.Operation == admissionv1.Update {
+		old := new(v1alpha1.ClusterCertificateUpload)
+
+		if err := w.decodeOld(req, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		defaultCertificateUploadSpec(&old.Spec.CertificateUploadSpec)
+
+		if skipValidation(ccu, &ccu.Spec, &old.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	errs := ValidateClusterCertificateUpload(ccu)
+
+	providerErrs, err := w.validateProviders(ctx, &ccu.Spec.CertificateUploadSpec, w.ClusterResourceNamespace, field.NewPath("spec"))
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return validationResponse(append(errs, providerErrs...))
+}
+
+// skipValidation returns true if an update doesn't change spec, e.g. the
+// controller adds or removes the finalizer, or the object is being deleted.
+// Objects accepted before a validation rule was added must still be
+// reconciled and deleted.
+func skipValidation(object client.Object, spec, oldSpec interface{}) bool {
+	return !object.GetDeletionTimestamp().IsZero() || equality.Semantic.DeepEqual(spec, oldSpec)
+}
+
+func patchResponse(req admission.Request, object runtime.Object) admission.Response {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to encode object: %w", err))
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, data)
+}
+
+func validationResponse(errs field.ErrorList) admission.Response {
+	if len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// DefaultCertificateUpload sets default values of a CertificateUpload.
+func DefaultCertificateUpload(cu *v1alpha1.CertificateUpload) {
+	defaultCertificateUploadSpec(&cu.Spec)
+}
+
+func defaultCertificateUploadSpec(spec *v1alpha1.CertificateUploadSpec) {
+	defaultCloudflare(spec.Cloudflare)
+
+	for i := range spec.Targets {
+		// Defaults are set in the provider
+		if spec.Targets[i].ProviderRef != nil {
+			continue
+		}
+
+		defaultCloudflare(spec.Targets[i].Cloudflare)
+		defaultGCP(spec.Targets[i].GCP)
+	}
+}
+
+func defaultCloudflare(spec *v1alpha1.CloudflareUploadSpec) {
+	if spec == nil {
+		return
+	}
+
+	if spec.BundleMethod == "" {
+		spec.BundleMethod = v1alpha1.CloudflareBundleMethodUbiquitous
+	}
+
+	if spec.Type == "" {
+		spec.Type = v1alpha1.CloudflareTypeLegacyCustom
+	}
+}
+
+func defaultGCP(spec *v1alpha1.GCPUploadSpec) {
+	if spec == nil {
+		return
+	}
+
+	if spec.Type == "" {
+		spec.Type = v1alpha1.GCPTypeCertificateManager
+	}
+
+	if spec.Location == "" {
+		spec.Location = v1alpha1.GCPLocationGlobal
+	}
+}
+
+// ValidateCertificateUpload returns errors of a CertificateUpload.
+func ValidateCertificateUpload(cu *v1alpha1.CertificateUpload) field.ErrorList {
+	return validateCertificateUploadSpec(&cu.Spec, field.NewPath("spec"))
+}
+
+// ValidateClusterCertificateUpload returns errors of a
+// ClusterCertificateUpload.
+func ValidateClusterCertificateUpload(ccu *v1alpha1.ClusterCertificateUpload) field.ErrorList {
+	var errs field.ErrorList
+
+	specPath := field.NewPath("spec")
+
+	if ccu.Spec.SecretNamespace == "" {
+		errs = append(errs, field.Required(specPath.Child("secretNamespace"), ""))
+	}
+
+	return append(errs, validateCertificateUploadSpec(&ccu.Spec.CertificateUploadSpec, specPath)...)
+}
+
+func validateCertificateUploadSpec(spec *v1alpha1.CertificateUploadSpec, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	switch {
+	case spec.SecretName != "" && spec.CertificateRef != nil:
+		errs = append(errs, field.Forbidden(specPath.Child("certificateRef"), "secretName and certificateRef can't be set at the same time"))
+	case spec.SecretName == "" && spec.CertificateRef == nil:
+		errs = append(errs, field.Required(specPath.Child("secretName"), "either secretName or certificateRef is required"))
+	case spec.CertificateRef != nil && spec.CertificateRef.Name == "":
+		errs = append(errs, field.Required(specPath.Child("certificateRef", "name"), ""))
+	}
+
+	if spec.Cloudflare != nil {
+		errs = append(errs, validateCloudflare(spec.Cloudflare, specPath.Child("cloudflare"))...)
+	}
+
+	if spec.ACM != nil {
+		errs = append(errs, validateACM(spec.ACM, specPath.Child("acm"))...)
+	}
+
+	if spec.UpdateStrategy != nil {
+		errs = append(errs, validateUpdateStrategy(spec.UpdateStrategy, specPath.Child("updateStrategy"))...)
+	}
+
+	for i := range spec.Targets {
+		target := &spec.Targets[i]
+		path := specPath.Child("targets").Index(i)
+
+		// Names of targets converted from the deprecated provider fields
+		switch {
+		case target.Name == "cloudflare" && spec.Cloudflare != nil:
+			errs = append(errs, field.Duplicate(path.Child("name"), target.Name))
+		case target.Name == "acm" && spec.ACM != nil:
+			errs = append(errs, field.Duplicate(path.Child("name"), target.Name))
+		}
+
+		errs = append(errs, validateTarget(target, path)...)
+	}
+
+	return errs
+}
+
+func validateUpdateStrategy(strategy *v1alpha1.UpdateStrategy, path *field.Path) field.ErrorList {
+	rollover := strategy.Rollover
+
+	if rollover == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+
+	path = path.Child("rollover")
+
+	if strategy.Type != v1alpha1.UpdateStrategyRollover {
+		errs = append(errs, field.Forbidden(path, "rollover can only be set when type is Rollover"))
+	}
+
+	if d := rollover.GracePeriod; d != nil && d.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("gracePeriod"), d.Duration.String(), "must not be negative"))
+	}
+
+	if d := rollover.ActivationTimeout; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("activationTimeout"), d.Duration.String(), "must be positive"))
+	}
+
+	return errs
+}
+
+func validateTarget(target *v1alpha1.UploadTarget, path *field.Path) field.ErrorList {
+	if target.ProviderRef != nil {
+		return validateProviderTarget(target, path)
+	}
+
+	var (
+		errs      field.ErrorList
+		providers int
+	)
+
+	if spec := target.Cloudflare; spec != nil {
+		providers++
+		errs = append(errs, validateCloudflare(spec, path.Child("cloudflare"))...)
+	}
+
+	if spec := target.ACM; spec != nil {
+		providers++
+		errs = append(errs, validateACM(spec, path.Child("acm"))...)
+	}
+
+	if spec := target.GCP; spec != nil {
+		providers++
+		errs = append(errs, validateGCP(spec, path.Child("gcp"))...)
+	}
+
+	switch {
+	case providers == 0:
+		errs = append(errs, field.Required(path, "a provider or providerRef must be set"))
+	case providers > 1:
+		errs = append(errs, field.Invalid(path, target.Name, "only one provider can be set"))
+	}
+
+	return errs
+}
+
+// validateProviderTarget validates a target referring to a provider. Only
+// settings overriding defaults of the provider can be set in the target.
+func validateProviderTarget(target *v1alpha1.UploadTarget, path *field.Path) field.ErrorList {
+	var (
+		errs    field.ErrorList
+		ref     = target.ProviderRef
+		refPath = path.Child("providerRef")
+	)
+
+	if ref.Kind != "" && !contains(providerKinds, ref.Kind) {
+		errs = append(errs, field.NotSupported(refPath.Child("kind"), ref.Kind, providerKinds))
+	}
+
+	if ref.Name == "" {
+		errs = append(errs, field.Required(refPath.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+			errs = append(errs, field.Invalid(refPath.Child("name"), ref.Name, msg))
+		}
+	}
+
+	providers := 0
+
+	for _, set := range []bool{target.Cloudflare != nil, target.ACM != nil, target.GCP != nil} {
+		if set {
+			providers++
+		}
+	}
+
+	if providers > 1 {
+		errs = append(errs, field.Invalid(path, target.Name, "only one provider can be set"))
+	}
+
+	if spec := target.Cloudflare; spec != nil {
+		path := path.Child("cloudflare")
+
+		errs = append(errs, forbidInProviderTarget(spec.Email != "", path.Child("email"))...)
+		errs = append(errs, forbidInProviderTarget(spec.APIKeySecretRef != nil, path.Child("apiKeySecretRef"))...)
+		errs = append(errs, forbidInProviderTarget(spec.APITokenSecretRef != nil, path.Child("apiTokenSecretRef"))...)
+		errs = append(errs, validateCloudflareOptions(spec, path)...)
+	}
+
+	if spec := target.ACM; spec != nil {
+		path := path.Child("acm")
+
+		errs = append(errs, forbidInProviderTarget(spec.AccessKeyIDSecretRef != nil, path.Child("accessKeyIdSecretRef"))...)
+		errs = append(errs, forbidInProviderTarget(spec.SecretAccessKeySecretRef != nil, path.Child("secretAccessKeySecretRef"))...)
+		errs = append(errs, forbidInProviderTarget(spec.Endpoint != "", path.Child("endpoint"))...)
+	}
+
+	if spec := target.GCP; spec != nil {
+		path := path.Child("gcp")
+
+		errs = append(errs, forbidInProviderTarget(spec.ServiceAccountKeySecretRef != nil, path.Child("serviceAccountKeySecretRef"))...)
+		errs = append(errs, forbidInProviderTarget(spec.Endpoint != "", path.Child("endpoint"))...)
+		errs = append(errs, validateGCPOptions(spec, path)...)
+	}
+
+	return errs
+}
+
+func forbidInProviderTarget(set bool, path *field.Path) field.ErrorList {
+	if !set {
+		return nil
+	}
+
+	return field.ErrorList{field.Forbidden(path, "must be set in the provider when providerRef is set")}
+}
+
+func validateCloudflare(spec *v1alpha1.CloudflareUploadSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.ZoneID == "" && spec.ZoneName == "" {
+		errs = append(errs, field.Required(path.Child("zoneId"), "either zoneId or zoneName is required"))
+	}
+
+	switch {
+	case spec.APIKeySecretRef != nil && spec.APITokenSecretRef != nil:
+		errs = append(errs, field.Forbidden(path.Child("apiKeySecretRef"), "apiKeySecretRef and apiTokenSecretRef can't be set at the same time"))
+	case spec.APIKeySecretRef == nil && spec.APITokenSecretRef == nil:
+		errs = append(errs, field.Required(path.Child("apiTokenSecretRef"), "either apiTokenSecretRef or apiKeySecretRef is required"))
+	case spec.APIKeySecretRef != nil && spec.Email == "":
+		errs = append(errs, field.Required(path.Child("email"), "email is required when apiKeySecretRef is set"))
+	}
+
+	errs = append(errs, validateSecretKeySelector(spec.APIKeySecretRef, path.Child("apiKeySecretRef"))...)
+	errs = append(errs, validateSecretKeySelector(spec.APITokenSecretRef, path.Child("apiTokenSecretRef"))...)
+	errs = append(errs, validateCloudflareOptions(spec, path)...)
+
+	return errs
+}
+
+func validateCloudflareOptions(spec *v1alpha1.CloudflareUploadSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.ZoneID != "" && spec.ZoneName != "" {
+		errs = append(errs, field.Forbidden(path.Child("zoneName"), "zoneId and zoneName can't be set at the same time"))
+	}
+
+	if spec.BundleMethod != "" && !contains(cloudflareBundleMethods, spec.BundleMethod) {
+		errs = append(errs, field.NotSupported(path.Child("bundleMethod"), spec.BundleMethod, cloudflareBundleMethods))
+	}
+
+	if spec.Type != "" && !contains(cloudflareTypes, spec.Type) {
+		errs = append(errs, field.NotSupported(path.Child("type"), spec.Type, cloudflareTypes))
+	}
+
+	return errs
+}
+
+func validateACM(spec *v1alpha1.ACMUploadSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.Region == "" {
+		errs = append(errs, field.Required(path.Child("region"), ""))
+	}
+
+	if spec.AccessKeyIDSecretRef != nil && spec.SecretAccessKeySecretRef == nil {
+		errs = append(errs, field.Required(path.Child("secretAccessKeySecretRef"), "secretAccessKeySecretRef is required when accessKeyIdSecretRef is set"))
+	}
+
+	if spec.AccessKeyIDSecretRef == nil && spec.SecretAccessKeySecretRef != nil {
+		errs = append(errs, field.Required(path.Child("accessKeyIdSecretRef"), "accessKeyIdSecretRef is required when secretAccessKeySecretRef is set"))
+	}
+
+	errs = append(errs, validateSecretKeySelector(spec.AccessKeyIDSecretRef, path.Child("accessKeyIdSecretRef"))...)
+	errs = append(errs, validateSecretKeySelector(spec.SecretAccessKeySecretRef, path.Child("secretAccessKeySecretRef"))...)
+
+	return errs
+}
+
+func validateGCP(spec *v1alpha1.GCPUploadSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.Project == "" {
+		errs = append(errs, field.Required(path.Child("project"), ""))
+	}
+
+	errs = append(errs, validateSecretKeySelector(spec.ServiceAccountKeySecretRef, path.Child("serviceAccountKeySecretRef"))...)
+	errs = append(errs, validateGCPOptions(spec, path)...)
+
+	return errs
+}
+
+func validateGCPOptions(spec *v1alpha1.GCPUploadSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.Type != "" && !contains(gcpTypes, spec.Type) {
+		errs = append(errs, field.NotSupported(path.Child("type"), spec.Type, gcpTypes))
+	}
+
+	if spec.Name == "" {
+		return errs
+	}
+
+	for _, msg := range validation.IsDNS1035Label(spec.Name) {
+		errs = append(errs, field.Invalid(path.Child("name"), spec.Name, msg))
+	}
+
+	if spec.Type == v1alpha1.GCPTypeCompute && len(spec.Name) > gcpComputeMaxNameLength {
+		errs = append(errs, field.TooLong(path.Child("name"), spec.Name, gcpComputeMaxNameLength))
+	}
+
+	return errs
+}
@@ -0,0 +1,330 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestWebhook(t *testing.T) *CertificateUploadWebhook {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &CertificateUploadWebhook{decoder: decoder}
+}
+
+func rawObject(t *testing.T, object runtime.Object) runtime.RawExtension {
+	t.Helper()
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return runtime.RawExtension{Raw: data}
+}
+
+func newTestSecretRef() *corev1.SecretKeySelector {
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "cloudflare"},
+		Key:                  "token",
+	}
+}
+
+func TestDefaultCertificateUpload(t *testing.T) {
+	cu := &v1alpha1.CertificateUpload{
+		Spec: v1alpha1.CertificateUploadSpec{
+			Cloudflare: &v1alpha1.CloudflareUploadSpec{},
+			Targets: []v1alpha1.UploadTarget{
+				{Name: "cloudflare", Cloudflare: &v1alpha1.CloudflareUploadSpec{Type: v1alpha1.CloudflareTypeSNICustom}},
+				{Name: "gcp", GCP: &v1alpha1.GCPUploadSpec{}},
+				{
+					Name:        "provider",
+					ProviderRef: &v1alpha1.ProviderReference{Name: "foo"},
+					GCP:         &v1alpha1.GCPUploadSpec{},
+				},
+			},
+		},
+	}
+
+	DefaultCertificateUpload(cu)
+
+	if s := cu.Spec.Cloudflare; s.BundleMethod != v1alpha1.CloudflareBundleMethodUbiquitous || s.Type != v1alpha1.CloudflareTypeLegacyCustom {
+		t.Errorf("cloudflare is not defaulted: %+v", s)
+	}
+
+	if s := cu.Spec.Targets[0].Cloudflare; s.BundleMethod != v1alpha1.CloudflareBundleMethodUbiquitous || s.Type != v1alpha1.CloudflareTypeSNICustom {
+		t.Errorf("cloudflare target is not defaulted: %+v", s)
+	}
+
+	if s := cu.Spec.Targets[1].GCP; s.Type != v1alpha1.GCPTypeCertificateManager || s.Location != v1alpha1.GCPLocationGlobal {
+		t.Errorf("gcp target is not defaulted: %+v", s)
+	}
+
+	// Defaults of targets referring to providers are set in the provider
+	if s := cu.Spec.Targets[2].GCP; s.Type != "" || s.Location != "" {
+		t.Errorf("provider target is defaulted: %+v", s)
+	}
+}
+
+func TestValidateCertificateUpload(t *testing.T) {
+	cloudflare := func() *v1alpha1.CloudflareUploadSpec {
+		return &v1alpha1.CloudflareUploadSpec{
+			ZoneName:          "example.com",
+			APITokenSecretRef: newTestSecretRef(),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		spec   v1alpha1.CertificateUploadSpec
+		errors int
+	}{
+		{
+			name: "valid",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{Name: "cloudflare", Cloudflare: cloudflare()},
+					{Name: "gcp", GCP: &v1alpha1.GCPUploadSpec{Project: "foo", Type: v1alpha1.GCPTypeCompute, Name: "api"}},
+					{Name: "provider", ProviderRef: &v1alpha1.ProviderReference{Name: "foo"}},
+				},
+			},
+		},
+		{
+			name:   "no secret",
+			spec:   v1alpha1.CertificateUploadSpec{},
+			errors: 1,
+		},
+		{
+			name: "secret and certificate",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName:     "foo",
+				CertificateRef: &v1alpha1.CertificateReference{Name: "foo"},
+			},
+			errors: 1,
+		},
+		{
+			name: "duplicate legacy target",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Cloudflare: cloudflare(),
+				Targets:    []v1alpha1.UploadTarget{{Name: "cloudflare", Cloudflare: cloudflare()}},
+			},
+			errors: 1,
+		},
+		{
+			name: "multiple providers in target",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{Name: "foo", Cloudflare: cloudflare(), ACM: &v1alpha1.ACMUploadSpec{Region: "us-east-1"}},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "multiple providers in provider target",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{
+						Name:        "foo",
+						ProviderRef: &v1alpha1.ProviderReference{Name: "foo"},
+						Cloudflare:  &v1alpha1.CloudflareUploadSpec{},
+						ACM:         &v1alpha1.ACMUploadSpec{},
+					},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "no provider in target",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets:    []v1alpha1.UploadTarget{{Name: "foo"}},
+			},
+			errors: 1,
+		},
+		{
+			name: "invalid cloudflare",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{Name: "foo", Cloudflare: &v1alpha1.CloudflareUploadSpec{BundleMethod: "foo"}},
+				},
+			},
+			errors: 3,
+		},
+		{
+			name: "invalid gcp name",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{Name: "foo", GCP: &v1alpha1.GCPUploadSpec{Project: "foo", Name: "Foo"}},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "credentials in provider target",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{
+						Name:        "foo",
+						ProviderRef: &v1alpha1.ProviderReference{Name: "foo"},
+						Cloudflare:  &v1alpha1.CloudflareUploadSpec{APITokenSecretRef: newTestSecretRef()},
+					},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "invalid provider reference",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets: []v1alpha1.UploadTarget{
+					{Name: "kind", ProviderRef: &v1alpha1.ProviderReference{Kind: "Secret", Name: "foo"}},
+					{Name: "empty", ProviderRef: &v1alpha1.ProviderReference{}},
+					{Name: "invalid", ProviderRef: &v1alpha1.ProviderReference{Name: "Foo_Bar"}},
+				},
+			},
+			errors: 3,
+		},
+		{
+			name: "rollover without rollover type",
+			spec: v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				UpdateStrategy: &v1alpha1.UpdateStrategy{
+					Type:     v1alpha1.UpdateStrategyInPlace,
+					Rollover: &v1alpha1.RolloverUpdateStrategy{},
+				},
+			},
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateCertificateUpload(&v1alpha1.CertificateUpload{Spec: test.spec})
+
+			if len(errs) != test.errors {
+				t.Fatalf("expected %d errors, got %v", test.errors, errs)
+			}
+		})
+	}
+}
+
+func TestValidateClusterCertificateUpload(t *testing.T) {
+	ccu := &v1alpha1.ClusterCertificateUpload{
+		Spec: v1alpha1.ClusterCertificateUploadSpec{
+			CertificateUploadSpec: v1alpha1.CertificateUploadSpec{SecretName: "foo"},
+		},
+	}
+
+	if errs := ValidateClusterCertificateUpload(ccu); len(errs) != 1 {
+		t.Fatalf("expected secretNamespace to be required, got %v", errs)
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	w := newTestWebhook(t)
+	invalid := &v1alpha1.CertificateUpload{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+	}
+	valid := invalid.DeepCopy()
+	valid.Spec.SecretName = "foo"
+
+	finalized := invalid.DeepCopy()
+	finalized.Finalizers = []string{"cert-uploader.dev/finalizer"}
+
+	deleted := invalid.DeepCopy()
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+
+	changed := invalid.DeepCopy()
+	changed.Spec.Suspend = true
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		object    runtime.Object
+		oldObject runtime.Object
+		allowed   bool
+	}{
+		{
+			name:      "create valid",
+			operation: admissionv1.Create,
+			object:    valid,
+			allowed:   true,
+		},
+		{
+			name:      "create invalid",
+			operation: admissionv1.Create,
+			object:    invalid,
+		},
+		{
+			name:      "spec unchanged",
+			operation: admissionv1.Update,
+			object:    finalized,
+			oldObject: invalid,
+			allowed:   true,
+		},
+		{
+			name:      "deleted",
+			operation: admissionv1.Update,
+			object:    deleted,
+			oldObject: changed,
+			allowed:   true,
+		},
+		{
+			name:      "spec changed",
+			operation: admissionv1.Update,
+			object:    changed,
+			oldObject: invalid,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: test.operation,
+					Object:    rawObject(t, test.object),
+				},
+			}
+
+			if test.oldObject != nil {
+				req.OldObject = rawObject(t, test.oldObject)
+			}
+
+			res := w.handleValidate(context.Background(), req)
+
+			if res.Allowed != test.allowed {
+				t.Fatalf("expected allowed to be %v, got %+v", test.allowed, res.Result)
+			}
+		})
+	}
+}
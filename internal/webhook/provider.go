@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateProviders validates targets against the providers they refer to.
+// Targets referring to providers which don't exist yet are allowed, because
+// the providers may be created later.
+func (w *CertificateUploadWebhook) validateProviders(ctx context.Context, spec *v1alpha1.CertificateUploadSpec, namespace string, specPath *field.Path) (field.ErrorList, error) {
+	if w.Client == nil {
+		return nil, nil
+	}
+
+	var errs field.ErrorList
+
+	for i := range spec.Targets {
+		target := &spec.Targets[i]
+
+		if target.ProviderRef == nil || target.ProviderRef.Name == "" {
+			continue
+		}
+
+		provider, err := w.getProvider(ctx, namespace, target.ProviderRef)
+		if err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		errs = append(errs, validateProviderSpec(target, provider, specPath.Child("targets").Index(i))...)
+	}
+
+	return errs, nil
+}
+
+func (w *CertificateUploadWebhook) getProvider(ctx context.Context, namespace string, ref *v1alpha1.ProviderReference) (*v1alpha1.ProviderSpec, error) {
+	if ref.Kind == v1alpha1.ClusterProviderKind {
+		provider := new(v1alpha1.ClusterProvider)
+
+		if err := w.Client.Get(ctx, types.NamespacedName{Name: ref.Name}, provider); err != nil {
+			return nil, fmt.Errorf("failed to get cluster provider %q: %w", ref.Name, err)
+		}
+
+		return &provider.Spec, nil
+	}
+
+	provider := new(v1alpha1.Provider)
+	key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+
+	if err := w.Client.Get(ctx, key, provider); err != nil {
+		return nil, fmt.Errorf("failed to get provider %q: %w", key, err)
+	}
+
+	return &provider.Spec, nil
+}
+
+// validateProviderSpec returns errors of a target merged with the provider it
+// refers to, i.e. the provider must have settings of exactly one provider,
+// which must match settings of the target, and settings required by the
+// provider must be set in either of them.
+func validateProviderSpec(target *v1alpha1.UploadTarget, spec *v1alpha1.ProviderSpec, path *field.Path) field.ErrorList {
+	var (
+		kinds   []string
+		refPath = path.Child("providerRef", "name")
+		name    = target.ProviderRef.Name
+	)
+
+	if spec.Cloudflare != nil {
+		kinds = append(kinds, "cloudflare")
+	}
+
+	if spec.ACM != nil {
+		kinds = append(kinds, "acm")
+	}
+
+	if spec.GCP != nil {
+		kinds = append(kinds, "gcp")
+	}
+
+	if len(kinds) == 0 {
+		return field.ErrorList{field.Invalid(refPath, name, "provider has no settings")}
+	}
+
+	if len(kinds) > 1 {
+		return field.ErrorList{field.Invalid(refPath, name, "provider has settings of more than one provider: "+strings.Join(kinds, ", "))}
+	}
+
+	var errs field.ErrorList
+
+	if target.Cloudflare != nil && spec.Cloudflare == nil {
+		errs = append(errs, field.Invalid(path.Child("cloudflare"), "", fmt.Sprintf("provider %q has no cloudflare settings", name)))
+	}
+
+	if target.ACM != nil && spec.ACM == nil {
+		errs = append(errs, field.Invalid(path.Child("acm"), "", fmt.Sprintf("provider %q has no acm settings", name)))
+	}
+
+	if target.GCP != nil && spec.GCP == nil {
+		errs = append(errs, field.Invalid(path.Child("gcp"), "", fmt.Sprintf("provider %q has no gcp settings", name)))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	switch {
+	case spec.Cloudflare != nil:
+		t := target.Cloudflare
+
+		if spec.Cloudflare.ZoneID == "" && spec.Cloudflare.ZoneName == "" && (t == nil || (t.ZoneID == "" && t.ZoneName == "")) {
+			errs = append(errs, field.Required(path.Child("cloudflare", "zoneId"), fmt.Sprintf("either zoneId or zoneName is required because provider %q has none", name)))
+		}
+
+	case spec.ACM != nil:
+		if spec.ACM.Region == "" && (target.ACM == nil || target.ACM.Region == "") {
+			errs = append(errs, field.Required(path.Child("acm", "region"), fmt.Sprintf("region is required because provider %q has none", name)))
+		}
+
+	case spec.GCP != nil:
+		if spec.GCP.Project == "" && (target.GCP == nil || target.GCP.Project == "") {
+			errs = append(errs, field.Required(path.Child("gcp", "project"), fmt.Sprintf("project is required because provider %q has none", name)))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tommy351/cert-uploader/pkg/apis/certuploader/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateProviders(t *testing.T) {
+	scheme := runtime.NewScheme()
+
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &CertificateUploadWebhook{
+		Client: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cloudflare"},
+				Spec: v1alpha1.ProviderSpec{
+					Cloudflare: &v1alpha1.CloudflareUploadSpec{APITokenSecretRef: newTestSecretRef()},
+				},
+			},
+			&v1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "empty"},
+			},
+			&v1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "multiple"},
+				Spec: v1alpha1.ProviderSpec{
+					ACM: &v1alpha1.ACMUploadSpec{Region: "us-east-1"},
+					GCP: &v1alpha1.GCPUploadSpec{Project: "foo"},
+				},
+			},
+			&v1alpha1.ClusterProvider{
+				ObjectMeta: metav1.ObjectMeta{Name: "acm"},
+				Spec: v1alpha1.ProviderSpec{
+					ACM: &v1alpha1.ACMUploadSpec{Region: "us-east-1"},
+				},
+			},
+		).Build(),
+	}
+
+	tests := []struct {
+		name   string
+		target v1alpha1.UploadTarget
+		errors int
+	}{
+		{
+			name: "valid",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "cloudflare"},
+				Cloudflare:  &v1alpha1.CloudflareUploadSpec{ZoneName: "example.com"},
+			},
+		},
+		{
+			name: "valid cluster provider",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Kind: v1alpha1.ClusterProviderKind, Name: "acm"},
+			},
+		},
+		{
+			name: "not found",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "foo"},
+			},
+		},
+		{
+			name: "cluster provider is not a provider",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "acm"},
+			},
+		},
+		{
+			name: "missing zone",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "cloudflare"},
+			},
+			errors: 1,
+		},
+		{
+			name: "mismatch",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Kind: v1alpha1.ClusterProviderKind, Name: "acm"},
+				Cloudflare:  &v1alpha1.CloudflareUploadSpec{ZoneName: "example.com"},
+			},
+			errors: 1,
+		},
+		{
+			name: "empty provider",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "empty"},
+			},
+			errors: 1,
+		},
+		{
+			name: "multiple providers",
+			target: v1alpha1.UploadTarget{
+				ProviderRef: &v1alpha1.ProviderReference{Name: "multiple"},
+			},
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			test.target.Name = "foo"
+			spec := &v1alpha1.CertificateUploadSpec{
+				SecretName: "foo",
+				Targets:    []v1alpha1.UploadTarget{test.target},
+			}
+
+			errs, err := w.validateProviders(context.Background(), spec, "default", field.NewPath("spec"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(errs) != test.errors {
+				t.Fatalf("expected %d errors, got %v", test.errors, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func validateSecretKeySelector(ref *corev1.SecretKeySelector, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if ref == nil {
+		return errs
+	}
+
+	if ref.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+
+	if ref.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), ""))
+	}
+
+	return errs
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
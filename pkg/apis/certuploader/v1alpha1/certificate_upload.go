@@ -3,11 +3,15 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.secretName`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`,priority=1
 // +kubebuilder:printcolumn:name="Upload",type=date,JSONPath=`.status.uploadTime`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
@@ -28,27 +32,262 @@ type CertificateUploadList struct {
 	Items []CertificateUpload `json:"items"`
 }
 
+func (c *CertificateUpload) GetUploadSpec() *CertificateUploadSpec {
+	return &c.Spec
+}
+
+func (c *CertificateUpload) GetUploadStatus() *CertificateUploadStatus {
+	return &c.Status
+}
+
+// GetSecretKey returns the namespaced name of the secret to upload.
+func (c *CertificateUpload) GetSecretKey() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: c.Namespace,
+		Name:      c.Spec.SecretName,
+	}
+}
+
+// DeletionPolicy describes what happens to uploaded certificates when a
+// CertificateUpload is deleted.
+// +kubebuilder:validation:Enum=Delete;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes uploaded certificates from providers.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+
+	// DeletionPolicyRetain keeps uploaded certificates on providers.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// DriftPolicy describes what happens when an uploaded certificate is changed
+// or deleted on the provider.
+// +kubebuilder:validation:Enum=Repair;Report
+type DriftPolicy string
+
+const (
+	// DriftPolicyRepair uploads the certificate again.
+	DriftPolicyRepair DriftPolicy = "Repair"
+
+	// DriftPolicyReport only reports the drift in conditions and events.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
+// UpdateStrategyType describes how uploaded certificates are replaced.
+// +kubebuilder:validation:Enum=InPlace;Rollover
+type UpdateStrategyType string
+
+const (
+	// UpdateStrategyInPlace updates uploaded certificates in place.
+	UpdateStrategyInPlace UpdateStrategyType = "InPlace"
+
+	// UpdateStrategyRollover uploads a new certificate alongside the old one,
+	// and deletes the old one after the new one is active. The old
+	// certificate is kept if the new one fails to become active.
+	UpdateStrategyRollover UpdateStrategyType = "Rollover"
+)
+
+type UpdateStrategy struct {
+	// +kubebuilder:default=InPlace
+	Type UpdateStrategyType `json:"type,omitempty"`
+	// Rollover is used when type is Rollover.
+	Rollover *RolloverUpdateStrategy `json:"rollover,omitempty"`
+}
+
+type RolloverUpdateStrategy struct {
+	// GracePeriod is how long the old certificate is kept after the new one
+	// is active. The default is 5 minutes.
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+	// ActivationTimeout is how long to wait for the new certificate to become
+	// active before rolling back. The default is 10 minutes.
+	ActivationTimeout *metav1.Duration `json:"activationTimeout,omitempty"`
+}
+
 type CertificateUploadSpec struct {
-	SecretName string                `json:"secretName"`
+	// SecretName is the name of the TLS secret to upload. Either secretName or
+	// certificateRef is required.
+	SecretName string `json:"secretName,omitempty"`
+	// CertificateRef refers to a cert-manager Certificate in the namespace
+	// where the secret is read from. The secret of the Certificate is uploaded
+	// when the Certificate is ready.
+	CertificateRef *CertificateReference `json:"certificateRef,omitempty"`
+	// +kubebuilder:default=Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// ResyncInterval is how often uploaded certificates are compared with the
+	// secret. It overrides the resync interval of the controller. Set it to 0
+	// to disable resync.
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+	// +kubebuilder:default=Repair
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// UpdateStrategy describes how uploaded certificates are replaced when the
+	// secret is changed.
+	UpdateStrategy *UpdateStrategy `json:"updateStrategy,omitempty"`
+	// Suspend stops uploading certificates until it is set to false. Uploaded
+	// certificates are still deleted when the resource is deleted.
+	Suspend bool `json:"suspend,omitempty"`
+	// Cloudflare is equivalent to a target named "cloudflare".
 	Cloudflare *CloudflareUploadSpec `json:"cloudflare,omitempty"`
+	// ACM is equivalent to a target named "acm".
+	ACM *ACMUploadSpec `json:"acm,omitempty"`
+
+	// +listType=map
+	// +listMapKey=name
+	Targets []UploadTarget `json:"targets,omitempty"`
+}
+
+// CertificateReference refers to a cert-manager Certificate.
+type CertificateReference struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// UploadTarget is a provider which the certificate is uploaded to. Exactly one
+// provider should be set, unless ProviderRef is set.
+type UploadTarget struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// ProviderRef refers to a provider containing credentials and default
+	// settings. Settings of the target override defaults of the provider,
+	// except credentials and endpoints.
+	ProviderRef *ProviderReference    `json:"providerRef,omitempty"`
+	Cloudflare  *CloudflareUploadSpec `json:"cloudflare,omitempty"`
+	ACM         *ACMUploadSpec        `json:"acm,omitempty"`
+	GCP         *GCPUploadSpec        `json:"gcp,omitempty"`
 }
 
+const (
+	// ConditionReady indicates that the certificate has been uploaded to all
+	// providers and is up to date.
+	ConditionReady = "Ready"
+
+	// ConditionUploaded indicates whether the last upload succeeded.
+	ConditionUploaded = "Uploaded"
+
+	// ConditionSecretValid indicates whether the referenced Secret exists and
+	// is a TLS secret.
+	ConditionSecretValid = "SecretValid"
+
+	// ConditionCertificateValid indicates whether the certificate chain and
+	// the private key in the Secret can be uploaded to all targets.
+	ConditionCertificateValid = "CertificateValid"
+
+	// ConditionCredentialsValid indicates whether provider credentials could
+	// be loaded and verified, and have the permissions to upload.
+	ConditionCredentialsValid = "CredentialsValid"
+
+	// ConditionDrifted indicates that an uploaded certificate does not match
+	// the secret anymore.
+	ConditionDrifted = "Drifted"
+)
+
 type CertificateUploadStatus struct {
-	SecretResourceVersion string                  `json:"secretResourceVersion,omitempty"`
-	UploadTime            *metav1.Time            `json:"uploadTime,omitempty"`
-	UpdateTime            *metav1.Time            `json:"updateTime,omitempty"`
-	ExpireTime            *metav1.Time            `json:"expireTime,omitempty"`
-	Cloudflare            *CloudflareUploadStatus `json:"cloudflare,omitempty"`
+	ObservedGeneration    int64  `json:"observedGeneration,omitempty"`
+	SecretResourceVersion string `json:"secretResourceVersion,omitempty"`
+	// SecretFingerprint is the SHA-256 hash of the certificate chain and the
+	// private key which were uploaded to all targets.
+	SecretFingerprint string       `json:"secretFingerprint,omitempty"`
+	UploadTime        *metav1.Time `json:"uploadTime,omitempty"`
+	UpdateTime        *metav1.Time `json:"updateTime,omitempty"`
+	ExpireTime        *metav1.Time `json:"expireTime,omitempty"`
+	LastSyncTime      *metav1.Time `json:"lastSyncTime,omitempty"`
+	// CertificateSecretName is the secret name of the referenced Certificate
+	// when it was last read.
+	CertificateSecretName string `json:"certificateSecretName,omitempty"`
+	// LastForceUpload is the value of the force-upload annotation which was
+	// handled last time.
+	LastForceUpload string `json:"lastForceUpload,omitempty"`
+	// Deprecated: Use targets instead.
+	Cloudflare *CloudflareUploadStatus `json:"cloudflare,omitempty"`
+	// Deprecated: Use targets instead.
+	ACM *ACMUploadStatus `json:"acm,omitempty"`
+
+	// +listType=map
+	// +listMapKey=name
+	Targets []UploadTargetStatus `json:"targets,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+type UploadTargetStatus struct {
+	Name                  string       `json:"name"`
+	Provider              string       `json:"provider,omitempty"`
+	CertificateID         string       `json:"certificateId,omitempty"`
+	SecretResourceVersion string       `json:"secretResourceVersion,omitempty"`
+	SecretFingerprint     string       `json:"secretFingerprint,omitempty"`
+	UploadTime            *metav1.Time `json:"uploadTime,omitempty"`
+	UpdateTime            *metav1.Time `json:"updateTime,omitempty"`
+	ExpireTime            *metav1.Time `json:"expireTime,omitempty"`
+	LastError             string       `json:"lastError,omitempty"`
+	// FailedAttempts is the number of consecutive failed uploads.
+	FailedAttempts int32 `json:"failedAttempts,omitempty"`
+	// NextRetryTime is when a temporarily failed upload will be retried.
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// UploadPending is true when an upload was started but has not
+	// succeeded yet, e.g. a forced upload failed temporarily. The upload is
+	// retried even if the secret is not changed.
+	UploadPending bool `json:"uploadPending,omitempty"`
+	// RolledBackFingerprint is the fingerprint of the secret whose
+	// certificate failed to become active and was rolled back. It is not
+	// uploaded again unless the secret is changed or the upload is forced.
+	RolledBackFingerprint string `json:"rolledBackFingerprint,omitempty"`
+	// ZoneID and ZoneName are the zone which the certificate was uploaded to,
+	// e.g. the resolved Cloudflare zone.
+	ZoneID   string `json:"zoneId,omitempty"`
+	ZoneName string `json:"zoneName,omitempty"`
+	// PreviousCertificateID is the certificate being replaced by a rollover.
+	// It is deleted after the new certificate is active and the grace period
+	// has passed, or kept as the current certificate if the rollover failed.
+	// It is also set when the certificate is replaced, e.g. the target is
+	// moved to another zone, until the previous certificate is deleted.
+	PreviousCertificateID string `json:"previousCertificateId,omitempty"`
+	PreviousZoneID        string `json:"previousZoneId,omitempty"`
+	PreviousZoneName      string `json:"previousZoneName,omitempty"`
+	// RolloverTime is when the current rollover started.
+	RolloverTime *metav1.Time `json:"rolloverTime,omitempty"`
+	// PreviousDeleteTime is when the previous certificate will be deleted. It
+	// is set when the new certificate is active.
+	PreviousDeleteTime *metav1.Time `json:"previousDeleteTime,omitempty"`
+	// Spec is the target when the certificate was last uploaded. It is used
+	// to delete the certificate after the target is removed from spec.
+	Spec *UploadTarget `json:"spec,omitempty"`
 }
 
+const (
+	CloudflareBundleMethodUbiquitous = "ubiquitous"
+	CloudflareBundleMethodOptimal    = "optimal"
+	CloudflareBundleMethodForce      = "force"
+)
+
+// CloudflareZoneAuto is a zone name which resolves the zone from hosts of the
+// certificate.
+const CloudflareZoneAuto = "auto"
+
+const (
+	CloudflareTypeLegacyCustom = "legacy_custom"
+	CloudflareTypeSNICustom    = "sni_custom"
+)
+
 type CloudflareUploadSpec struct {
-	ZoneID            string                     `json:"zoneId"`
-	Email             string                     `json:"email,omitempty"`
-	APIKeySecretRef   *corev1.SecretKeySelector  `json:"apiKeySecretRef,omitempty"`
-	APITokenSecretRef *corev1.SecretKeySelector  `json:"apiTokenSecretRef,omitempty"`
-	BundleMethod      string                     `json:"bundleMethod,omitempty"`
-	Type              string                     `json:"type,omitempty"`
-	GeoRestrictions   *CloudflareGeoRestrictions `json:"geoRestrictions,omitempty"`
+	// Either ZoneID or ZoneName is required unless it is set in the provider.
+	ZoneID string `json:"zoneId,omitempty"`
+	// ZoneName is resolved to a zone ID with the zones API. If it is "auto",
+	// the most specific zone containing a host of the certificate is used.
+	ZoneName string `json:"zoneName,omitempty"`
+	// Email is required when APIKeySecretRef is set.
+	Email string `json:"email,omitempty"`
+	// Either APIKeySecretRef or APITokenSecretRef should be set.
+	APIKeySecretRef   *corev1.SecretKeySelector `json:"apiKeySecretRef,omitempty"`
+	APITokenSecretRef *corev1.SecretKeySelector `json:"apiTokenSecretRef,omitempty"`
+	// BundleMethod is one of ubiquitous, optimal or force. It defaults to
+	// ubiquitous.
+	BundleMethod string `json:"bundleMethod,omitempty"`
+	// Type is one of legacy_custom or sni_custom. It defaults to
+	// legacy_custom.
+	Type            string                     `json:"type,omitempty"`
+	GeoRestrictions *CloudflareGeoRestrictions `json:"geoRestrictions,omitempty"`
 }
 
 type CloudflareGeoRestrictions struct {
@@ -58,3 +297,65 @@ type CloudflareGeoRestrictions struct {
 type CloudflareUploadStatus struct {
 	CertificateID string `json:"certificateId,omitempty"`
 }
+
+type ACMUploadSpec struct {
+	// Region is required unless it is set in the provider.
+	Region string `json:"region,omitempty"`
+	// CertificateARN is the ARN of an existing certificate to reimport into.
+	// A new certificate is imported when it is empty.
+	CertificateARN string `json:"certificateArn,omitempty"`
+	// AccessKeyIDSecretRef and SecretAccessKeySecretRef are optional. The
+	// default credential chain of the controller (e.g. IAM roles for service
+	// accounts) is used when they are not set.
+	AccessKeyIDSecretRef     *corev1.SecretKeySelector `json:"accessKeyIdSecretRef,omitempty"`
+	SecretAccessKeySecretRef *corev1.SecretKeySelector `json:"secretAccessKeySecretRef,omitempty"`
+	Tags                     map[string]string         `json:"tags,omitempty"`
+	// Endpoint overrides the ACM API endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+type ACMUploadStatus struct {
+	CertificateARN string `json:"certificateArn,omitempty"`
+}
+
+const (
+	// GCPTypeCertificateManager uploads certificates as self-managed
+	// certificates of Certificate Manager.
+	GCPTypeCertificateManager = "CertificateManager"
+
+	// GCPTypeCompute uploads certificates as Compute Engine SSL certificates,
+	// which are used by classic HTTPS load balancers.
+	GCPTypeCompute = "Compute"
+)
+
+// GCPLocationGlobal is the location of global certificates.
+const GCPLocationGlobal = "global"
+
+type GCPUploadSpec struct {
+	// Project is the ID of the Google Cloud project. It is required unless it
+	// is set in the provider.
+	Project string `json:"project,omitempty"`
+	// Type is either CertificateManager or Compute. It defaults to
+	// CertificateManager.
+	Type string `json:"type,omitempty"`
+	// Location is the location of Certificate Manager certificates, or the
+	// region of Compute SSL certificates. It defaults to global.
+	Location string `json:"location,omitempty"`
+	// Name is the name of the Certificate Manager certificate. Compute SSL
+	// certificates are immutable, so Name is the prefix of their names, and a
+	// new version is created on every update. Old versions are deleted when
+	// they are not used by load balancers anymore. A name is generated when
+	// it is empty.
+	Name string `json:"name,omitempty"`
+	// Description of uploaded certificates. A marker is appended to
+	// descriptions of Compute SSL certificates, which identifies versions
+	// created by the controller for the target.
+	Description string `json:"description,omitempty"`
+	// Labels are added to Certificate Manager certificates.
+	Labels map[string]string `json:"labels,omitempty"`
+	// ServiceAccountKeySecretRef refers to a JSON key of a service account.
+	// The workload identity of the controller is used when it is not set.
+	ServiceAccountKeySecretRef *corev1.SecretKeySelector `json:"serviceAccountKeySecretRef,omitempty"`
+	// Endpoint overrides the URL of the API, e.g. for local testing.
+	Endpoint string `json:"endpoint,omitempty"`
+}
@@ -0,0 +1,58 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Secret Namespace",type=string,JSONPath=`.spec.secretNamespace`
+// +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.secretName`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`,priority=1
+// +kubebuilder:printcolumn:name="Upload",type=date,JSONPath=`.status.uploadTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterCertificateUpload uploads a secret in any namespace. Secrets
+// referenced by targets are read from the cluster resource namespace of the
+// controller.
+type ClusterCertificateUpload struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterCertificateUploadSpec `json:"spec,omitempty"`
+	Status CertificateUploadStatus      `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type ClusterCertificateUploadList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterCertificateUpload `json:"items"`
+}
+
+type ClusterCertificateUploadSpec struct {
+	// +kubebuilder:validation:MinLength=1
+	SecretNamespace       string `json:"secretNamespace"`
+	CertificateUploadSpec `json:",inline"`
+}
+
+func (c *ClusterCertificateUpload) GetUploadSpec() *CertificateUploadSpec {
+	return &c.Spec.CertificateUploadSpec
+}
+
+func (c *ClusterCertificateUpload) GetUploadStatus() *CertificateUploadStatus {
+	return &c.Status
+}
+
+func (c *ClusterCertificateUpload) GetSecretKey() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: c.Spec.SecretNamespace,
+		Name:      c.Spec.SecretName,
+	}
+}
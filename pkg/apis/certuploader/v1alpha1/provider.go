@@ -0,0 +1,72 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+
+// Provider contains credentials and default settings of a provider, which can
+// be referenced by CertificateUploads in the same namespace.
+type Provider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ProviderSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type ProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Provider `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// ClusterProvider is a Provider which can be referenced by CertificateUploads
+// in all namespaces. Secrets are read from the cluster resource namespace of
+// the controller.
+type ClusterProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ProviderSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type ClusterProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterProvider `json:"items"`
+}
+
+// ProviderSpec contains settings of exactly one provider. Credentials can only
+// be set in a provider, while other settings are defaults which can be
+// overridden by targets.
+type ProviderSpec struct {
+	Cloudflare *CloudflareUploadSpec `json:"cloudflare,omitempty"`
+	ACM        *ACMUploadSpec        `json:"acm,omitempty"`
+	GCP        *GCPUploadSpec        `json:"gcp,omitempty"`
+}
+
+const (
+	ProviderKind                 = "Provider"
+	ClusterProviderKind          = "ClusterProvider"
+	CertificateUploadKind        = "CertificateUpload"
+	ClusterCertificateUploadKind = "ClusterCertificateUpload"
+)
+
+// ProviderReference refers to a Provider or a ClusterProvider.
+type ProviderReference struct {
+	// +kubebuilder:validation:Enum=Provider;ClusterProvider
+	// +kubebuilder:default=Provider
+	Kind string `json:"kind,omitempty"`
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
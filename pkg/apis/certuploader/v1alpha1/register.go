@@ -22,6 +22,12 @@ func AddKnownTypes(scheme *runtime.Scheme) error {
 		GroupVersion,
 		&CertificateUpload{},
 		&CertificateUploadList{},
+		&ClusterCertificateUpload{},
+		&ClusterCertificateUploadList{},
+		&Provider{},
+		&ProviderList{},
+		&ClusterProvider{},
+		&ClusterProviderList{},
 	)
 	metav1.AddToGroupVersion(scheme, GroupVersion)
 